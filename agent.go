@@ -2,31 +2,128 @@ package cron
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/hashicorp/memberlist"
+	bolt "go.etcd.io/bbolt"
 )
 
+const metaRefreshInterval = 10 * time.Second
+
+// restoreFailureGrace is how long an agent failing a strict restore keeps
+// serving its status before exiting
+const restoreFailureGrace = 30 * time.Second
+
 var (
-	ErrJobNotSupport = errors.New("unsupported job")
-	ErrJobNameEmpty  = errors.New("job node can not be empty")
+	ErrJobNotSupport  = errors.New("unsupported job")
+	ErrJobNameEmpty   = errors.New("job node can not be empty")
+	ErrJobNameInvalid = errors.New("invalid job name")
+	ErrNodeDeparted   = errors.New("node departed while running")
+	ErrNotRunning     = errors.New("agent not running")
 )
 
 type Agent struct {
-	cron     *Cron
-	executor *Executor
-	server   http.Server
+	entries    EntryStore
+	storage    *storage
+	server     http.Server
+	reconciler *reconciler
+
+	custom struct {
+		maxHistoryNum int64
+		pageSize      int64
+		timeout       time.Duration
+		strictRestore bool
+	}
+
+	mu         sync.Mutex
+	namespaces map[string]*Namespace
+	running    bool
+	failure    string // why the agent is exiting on start
+
+	roles       []string
+	labels      map[string]string
+	keyRotation bool // by the http api
+	metaMu      sync.Mutex
+	lastMeta    string
+
+	// ctx is canceled on shutdown, aborting the pending api calls
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	stop chan os.Signal
 }
 
 func NewAgent(conf *Conf) *Agent {
-	cli := redis.NewClient(&conf.Base.RedisOptions)
+	storage := newStorage(conf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a := &Agent{
+		entries: newEntryStore(conf, storage.backup),
+		storage: storage,
+		server:  http.Server{Addr: conf.Base.HttpAddr},
+
+		namespaces:  make(map[string]*Namespace),
+		roles:       conf.Gossip.Roles,
+		labels:      conf.Gossip.Labels,
+		keyRotation: conf.Gossip.KeyRotation,
+
+		ctx:    ctx,
+		cancel: cancel,
+
+		stop: make(chan os.Signal),
+	}
+
+	// custom
+	a.custom.maxHistoryNum = conf.Custom.MaxHistoryNum
+	a.custom.pageSize = conf.Custom.FetchPageSize
+	a.custom.timeout = time.Duration(conf.Custom.StoreTimeout) * time.Millisecond
+	a.custom.strictRestore = conf.Custom.StrictRestore
+
+	a.reconciler = &reconciler{
+		agent:    a,
+		interval: time.Duration(conf.Custom.ReconcileInterval) * time.Second,
+	}
+
+	a.Namespace(DefaultNamespace)
+	a.entries.OnMemberEvent(a.reconcileMember)
+	return a
+}
+
+func newEntryStore(conf *Conf, backup EntryBackup) EntryStore {
+	var (
+		ttl     = time.Duration(conf.Custom.TombstoneTTL) * time.Second
+		timeout = time.Duration(conf.Custom.StoreTimeout) * time.Millisecond
+	)
+
+	if conf.Base.Standalone {
+		entries := NewLocalEntries(backup, conf.Gossip.NodeName)
+		entries.WithTombstoneTTL(ttl)
+		entries.WithTimeout(timeout)
+		return entries
+	}
+
+	if conf.Base.EntryStore == "redis" {
+		key := conf.Custom.KeyEntry
+		if len(conf.Base.RedisCluster.Addrs) > 0 {
+			key = hashTag(key)
+		}
+		entries := NewRedisEntries(backup, newRedisClient(conf), key, conf.Gossip.NodeName)
+		entries.WithTombstoneTTL(ttl)
+		entries.WithTimeout(timeout)
+		return entries
+	}
 
 	// gossip
 	gossipConf := memberlist.DefaultLANConfig()
@@ -39,169 +136,489 @@ func NewAgent(conf *Conf) *Agent {
 	gossipConf.BindAddr = conf.Gossip.BindAddr
 	gossipConf.BindPort = conf.Gossip.BindPort
 	gossipConf.Name = conf.Gossip.NodeName
+	gossipConf.AdvertiseAddr = conf.Gossip.AdvertiseAddr
+	gossipConf.AdvertisePort = conf.Gossip.AdvertisePort
+	gossipConf.Label = conf.Gossip.Label
 
-	timeline := NewRedisTimeline(cli, conf.Custom.KeyTimeline)
-	entries := NewGossipEntries(cli, gossipConf)
-	executor := NewExecutor(cli, entries.list.LocalNode().Name)
-	cron := NewCron(entries, timeline, executor.Receiver())
-
-	// custom
-	entries.WithKeyPrefix(conf.Custom.KeyEntry)
-	executor.WithKeyPrefix(conf.Custom.KeyExecutor)
-	executor.WithMaxHistoryNum(conf.Custom.MaxHistoryNum)
+	keyring, err := newKeyring(conf.Gossip.SecretKey, conf.Gossip.SecondaryKeys)
+	if err != nil {
+		Logger.Fatalln(err)
+	}
+	gossipConf.Keyring = keyring
+	if conf.Gossip.AllowPlaintext {
+		gossipConf.GossipVerifyIncoming = false
+		gossipConf.GossipVerifyOutgoing = false
+	}
 
-	return &Agent{
-		cron:     cron,
-		executor: executor,
-		server:   http.Server{Addr: conf.Base.HttpAddr},
+	entries := NewGossipEntries(backup, gossipConf)
+	entries.WithTombstoneTTL(ttl)
+	entries.WithTimeout(timeout)
+	return entries
+}
 
-		stop: make(chan os.Signal),
-	}
+// storage creates the timeline and the execution store of every namespace,
+// entries of all the namespaces share one backup.
+type storage struct {
+	backup     EntryBackup
+	timeline   func(namespace string) Timeline
+	executions func(namespace string) ExecutionStore
+	stream     func(namespace string) *ChangeStream // nil if not supported
+	close      func()
 }
 
-// Join must call before Run()
-func (a *Agent) Join(existing []string) { a.cron.entries.Join(existing) }
+func newStorage(conf *Conf) *storage {
+	keyTimeline, keyEntry, keyExecutor := conf.Custom.KeyTimeline, conf.Custom.KeyEntry, conf.Custom.KeyExecutor
+	maxHistoryNum := conf.Custom.MaxHistoryNum
+
+	var s *storage
+
+	switch conf.Base.Storage {
+	case "memory":
+		s = &storage{
+			backup: NewMemoryEntryBackup(),
+			timeline: func(namespace string) Timeline {
+				return NewMemoryTimeline()
+			},
+			executions: func(namespace string) ExecutionStore {
+				return NewMemoryExecutionStore(maxHistoryNum)
+			},
+			close: func() {},
+		}
 
-func (a *Agent) Run() {
-	go a.executor.consume()
-	go a.cron.run()
-	go a.serveHTTP()
+	case "sql":
+		db, err := sql.Open(conf.Base.SQL.Driver, conf.Base.SQL.DSN)
+		if err != nil {
+			Logger.Fatalln(err)
+		}
+		s = &storage{
+			backup: NewSQLEntryBackup(db, keyEntry),
+			timeline: func(namespace string) Timeline {
+				return NewSQLTimeline(db, namespaceKey(keyTimeline, namespace))
+			},
+			executions: func(namespace string) ExecutionStore {
+				return NewSQLExecutionStore(db, namespaceKey(keyExecutor, namespace), maxHistoryNum)
+			},
+			close: func() { db.Close() },
+		}
 
-	signal.Notify(a.stop, syscall.SIGINT)
-	s := <-a.stop
-	Logger.Infof("receive a signal %s, begin to shutdown...", s.String())
-	a.close()
-}
+	case "bolt":
+		db, err := bolt.Open(conf.Base.Bolt.Path, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			Logger.Fatalln(err)
+		}
+		s = &storage{
+			backup: NewBoltEntryBackup(db, keyEntry),
+			timeline: func(namespace string) Timeline {
+				return NewBoltTimeline(db, namespaceKey(keyTimeline, namespace))
+			},
+			executions: func(namespace string) ExecutionStore {
+				return NewBoltExecutionStore(db, namespaceKey(keyExecutor, namespace), maxHistoryNum)
+			},
+			close: func() { db.Close() },
+		}
 
-func (a *Agent) Register(jobs ...Job) error {
-	for _, job := range jobs {
-		if err := a.register(job); err != nil {
-			return err
+	default:
+		cli := newRedisClient(conf)
+		key := func(k string) string { return k }
+		if len(conf.Base.RedisCluster.Addrs) > 0 {
+			// keep the keys used by one script in the same slot
+			key = hashTag
+		}
+		s = &storage{
+			backup: NewRedisEntryBackup(cli, key(keyEntry)),
+			timeline: func(namespace string) Timeline {
+				return NewRedisTimeline(cli, key(namespaceKey(keyTimeline, namespace)))
+			},
+			executions: func(namespace string) ExecutionStore {
+				return NewRedisExecutionStore(cli, key(namespaceKey(keyExecutor, namespace)), maxHistoryNum)
+			},
+			close: func() { cli.Close() },
+		}
+		if conf.Custom.StreamMaxLen > 0 {
+			s.stream = func(namespace string) *ChangeStream {
+				return NewChangeStream(cli, key(namespaceKey(conf.Custom.KeyStream, namespace)), conf.Custom.StreamMaxLen)
+			}
 		}
 	}
-	return nil
+
+	if s.stream == nil {
+		s.stream = func(namespace string) *ChangeStream { return nil }
+	}
+	return s
 }
 
-func (a *Agent) register(job Job) error {
-	if job.Name() == "" {
-		return ErrJobNameEmpty
+func newRedisClient(conf *Conf) redis.UniversalClient {
+	opt := conf.Base.RedisOptions
+
+	if sentinel := conf.Base.RedisSentinel; sentinel.MasterName != "" {
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       sentinel.MasterName,
+			SentinelAddrs:    sentinel.Addrs,
+			SentinelPassword: sentinel.Password,
+
+			Username:     opt.Username,
+			Password:     opt.Password,
+			DB:           opt.DB,
+			MaxRetries:   opt.MaxRetries,
+			DialTimeout:  opt.DialTimeout,
+			ReadTimeout:  opt.ReadTimeout,
+			WriteTimeout: opt.WriteTimeout,
+			PoolSize:     opt.PoolSize,
+			MinIdleConns: opt.MinIdleConns,
+			PoolTimeout:  opt.PoolTimeout,
+			IdleTimeout:  opt.IdleTimeout,
+			TLSConfig:    opt.TLSConfig,
+		})
 	}
-	a.executor.Register(job)
-	return nil
+
+	if cluster := conf.Base.RedisCluster; len(cluster.Addrs) > 0 {
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs: cluster.Addrs,
+
+			Username:     opt.Username,
+			Password:     opt.Password,
+			MaxRetries:   opt.MaxRetries,
+			DialTimeout:  opt.DialTimeout,
+			ReadTimeout:  opt.ReadTimeout,
+			WriteTimeout: opt.WriteTimeout,
+			PoolSize:     opt.PoolSize,
+			MinIdleConns: opt.MinIdleConns,
+			PoolTimeout:  opt.PoolTimeout,
+			IdleTimeout:  opt.IdleTimeout,
+			TLSConfig:    opt.TLSConfig,
+		})
+	}
+
+	return redis.NewClient(&opt)
 }
 
-func (a *Agent) serveHTTP() {
-	a.server.Handler = a.Router()
-	Logger.Info("start admin http server: ", a.server.Addr)
-	Logger.Info(a.server.ListenAndServe())
+// hashTag makes all the keys starting with prefix hash to the same redis
+// cluster slot.
+func hashTag(prefix string) string {
+	if strings.Contains(prefix, "{") {
+		return prefix
+	}
+	return "{" + prefix + "}"
 }
 
-func (a *Agent) close() {
-	a.server.Close()
-	a.cron.close()
-	a.executor.close()
-	a.cron.timeline.Close()
-	a.cron.entries.Close()
-	Logger.Info("agent shutdown gracefully")
+// reconcileMember finishes the executions a departed node was running, in
+// all the namespaces.
+func (a *Agent) reconcileMember(e MemberEvent) {
+	if e.Type == MemberJoin {
+		return
+	}
+
+	a.mu.Lock()
+	namespaces := make([]*Namespace, 0, len(a.namespaces))
+	for _, n := range a.namespaces {
+		namespaces = append(namespaces, n)
+	}
+	a.mu.Unlock()
+
+	for _, ns := range namespaces {
+		n, err := ns.executor.abandon(a.ctx, e.Member.Name, ErrNodeDeparted)
+		if err != nil {
+			Logger.Errorf("reconcile executions of %s failed: %s", e.Member.Name, err.Error())
+			continue
+		}
+		if n > 0 {
+			Logger.Warnf("finish %d executions of departed node %s in namespace %s", n, e.Member.Name, ns.name)
+		}
+	}
 }
 
-func (a *Agent) Add(spec, jobName string) error {
-	if err := a.validate(jobName); err != nil {
-		return err
+// OnMemberEvent adds a hook called on every membership event
+func (a *Agent) OnMemberEvent(hook func(MemberEvent)) { a.entries.OnMemberEvent(hook) }
+
+// MemberEvents lists the latest membership events, oldest first
+func (a *Agent) MemberEvents() []MemberEvent { return a.entries.MemberEvents() }
+
+// Reconcile checks the timelines, the entry backup and the entries at once,
+// see ReconcileReport.
+func (a *Agent) Reconcile() ReconcileReport { return a.reconciler.reconcile(a.ctx) }
+
+// Join must call before Run()
+func (a *Agent) Join(existing []string) { a.entries.Join(existing) }
+
+// Namespace gets the namespace called name, it is created if not exists.
+// Namespaces created after Run start immediately.
+func (a *Agent) Namespace(name string) (*Namespace, error) {
+	if !validNamespace(name) {
+		return nil, ErrNamespaceInvalid
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n, ok := a.namespaces[name]; ok {
+		return n, nil
+	}
+	if d, ok := a.namespaces[DefaultNamespace]; ok {
+		for _, job := range d.Jobs() {
+			if namespaceOfKey(job) == name {
+				return nil, fmt.Errorf("%w: %s", ErrNamespaceConflict, job)
+			}
+		}
+	}
+
+	node := a.entries.LocalMember().Name
+	timeline := a.storage.timeline(name)
+	stream := a.storage.stream(name)
+	if stream != nil {
+		timeline = NewStreamTimeline(timeline, stream, node)
 	}
 
-	return a.cron.Add(spec, jobName)
+	executor := NewExecutor(a.storage.executions(name), node)
+	cron := NewCron(a.entries, timeline, executor.Receiver())
+
+	cron.WithNamespace(name)
+	cron.WithPageSize(a.custom.pageSize)
+	cron.WithTimeout(a.custom.timeout)
+	executor.WithMaxHistoryNum(a.custom.maxHistoryNum)
+	executor.WithTimeout(a.custom.timeout)
+
+	n := &Namespace{
+		name:     name,
+		cron:     cron,
+		executor: executor,
+		stream:   stream,
+		ctx:      a.ctx,
+
+		onRegister: a.refreshMeta,
+	}
+	if name == DefaultNamespace {
+		n.checkJob = a.checkDefaultJob
+	}
+	cron.WithClaimPolicy(newPlacementPolicy(n, a.entries, a.labels))
+	a.namespaces[name] = n
+
+	if a.running {
+		n.run()
+	}
+	return n, nil
 }
 
-func (a *Agent) Active(jobName string) error {
-	if err := a.validate(jobName); err != nil {
-		return err
+// checkDefaultJob refuses a job of the default namespace whose key would be
+// the key of a job of another namespace, e.g. "team/x" and job "x" of
+// namespace "team".
+func (a *Agent) checkDefaultJob(name string) error {
+	ns := namespaceOfKey(name)
+	if ns == DefaultNamespace {
+		return nil
 	}
 
-	return a.cron.Activate(jobName)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.namespaces[ns]; ok {
+		return fmt.Errorf("%w: conflicts with namespace %s", ErrJobNameInvalid, ns)
+	}
+	return nil
 }
 
-func (a *Agent) Pause(jobName string) error {
-	if err := a.validate(jobName); err != nil {
-		return err
+// lookup gets an existing namespace, an empty name means the default one
+func (a *Agent) lookup(name string) (*Namespace, error) {
+	if name == "" {
+		name = DefaultNamespace
 	}
 
-	return a.cron.Pause(jobName)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	n, ok := a.namespaces[name]
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+	return n, nil
 }
 
-func (a *Agent) Remove(jobName string) error {
-	if err := a.validate(jobName); err != nil {
-		return err
+// Namespaces lists the names of all the namespaces
+func (a *Agent) Namespaces() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	names := make([]string, 0, len(a.namespaces))
+	for name := range a.namespaces {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	return a.cron.Remove(jobName)
+func (a *Agent) defaultNamespace() *Namespace {
+	n, _ := a.lookup(DefaultNamespace)
+	return n
 }
 
-func (a *Agent) ExecuteOnce(jobName string) error {
-	if err := a.validate(jobName); err != nil {
-		return err
+func (a *Agent) Run() {
+	signal.Notify(a.stop, syscall.SIGINT)
+
+	// only the status is served while restoring
+	go a.serveHTTP()
+
+	if err := a.restore(); err != nil {
+		a.mu.Lock()
+		a.failure = err.Error()
+		a.mu.Unlock()
+
+		Logger.Errorf("%s, exit in %s", err.Error(), restoreFailureGrace)
+		select {
+		case <-time.After(restoreFailureGrace):
+		case <-a.stop:
+		}
+		a.server.Close()
+		Logger.Fatalln(err)
 	}
 
-	go a.executor.executeTask(context.Background(), jobName)
-	Logger.Info("execute once:", jobName)
-	return nil
+	a.mu.Lock()
+	for _, n := range a.namespaces {
+		n.run()
+	}
+	a.running = true
+	a.mu.Unlock()
+
+	go a.advertise()
+	go a.reconciler.run(a.ctx)
+
+	s := <-a.stop
+	Logger.Infof("receive a signal %s, begin to shutdown...", s.String())
+	a.close()
 }
 
-func (a *Agent) Schedule() ([]entryRecord, error) {
-	events, err := a.cron.Events()
-	if err != nil {
-		return nil, err
+// restore restores the entries of every namespace before running, in strict
+// mode it fails if some are not restored.
+func (a *Agent) restore() error {
+	a.mu.Lock()
+	namespaces := make([]*Namespace, 0, len(a.namespaces))
+	for _, n := range a.namespaces {
+		namespaces = append(namespaces, n)
 	}
+	a.mu.Unlock()
+
+	for _, n := range namespaces {
+		report := n.cron.Restore()
+		if a.custom.strictRestore && !report.Complete() {
+			return fmt.Errorf("restore of namespace %s incomplete: missing %v, corrupt %v %s",
+				n.name, report.Missing, report.Corrupt, report.Err)
+		}
+	}
+	return nil
+}
 
-	var results = make([]entryRecord, len(events))
+// Status is the startup status of the agent
+type Status struct {
+	Node    string `json:"node"`
+	Running bool   `json:"running"`
+	// Restored is set once the entries of every namespace are restored
+	// completely
+	Restored bool `json:"restored"`
+	// Restore is the report of every namespace, nil until restored
+	Restore map[string]*RestoreReport `json:"restore"`
+	// Err is why the agent is exiting, a strict restore failed
+	Err string `json:"error,omitempty"`
+}
 
-	for i, event := range events {
-		results[i] = entryRecord{
-			Name:      event.Name,
-			Next:      event.Time.Unix() * 1000,
-			Displayed: event.Displayed,
+func (a *Agent) Status() Status {
+	a.mu.Lock()
+	namespaces := make(map[string]*Namespace, len(a.namespaces))
+	for name, n := range a.namespaces {
+		namespaces[name] = n
+	}
+	running, failure := a.running, a.failure
+	a.mu.Unlock()
+
+	s := Status{
+		Node:     a.entries.LocalMember().Name,
+		Running:  running,
+		Restored: true,
+		Restore:  make(map[string]*RestoreReport, len(namespaces)),
+		Err:      failure,
+	}
+	// the namespaces being restored are not waited for
+	for name, n := range namespaces {
+		report, ok := n.cron.Restored()
+		if !ok {
+			s.Restored = false
+			s.Restore[name] = nil
+			continue
 		}
-		if e, ok := a.cron.entries.Get(event.Name); ok {
-			results[i].Spec = e.Spec
+		if !report.Complete() {
+			s.Restored = false
 		}
+		s.Restore[name] = &report
 	}
-	return results, nil
+	return s
 }
 
-func (a *Agent) Running() ([]Execution, error) {
-	return a.executor.Running()
+// Register registers jobs in the default namespace
+func (a *Agent) Register(jobs ...Job) error {
+	return a.defaultNamespace().Register(jobs...)
 }
 
-func (a *Agent) History(jobName string, offset, size int64) ([]Execution, int64, error) {
-	total := a.executor.maxHistoryNum
-	if jobName == "" {
-		return nil, total, ErrJobNameEmpty
+// advertise refreshes the meta of the local node periodically, as the load
+// changes.
+func (a *Agent) advertise() {
+	ticker := time.NewTicker(metaRefreshInterval)
+	defer ticker.Stop()
+
+	a.refreshMeta()
+	for {
+		select {
+		case <-ticker.C:
+			a.refreshMeta()
+		case <-a.ctx.Done():
+			return
+		}
 	}
-	executions, err := a.executor.History(jobName, offset, size)
-	return executions, total, err
 }
 
-func (a *Agent) Jobs() []string {
-	return a.executor.Jobs()
+func (a *Agent) serveHTTP() {
+	a.server.Handler = a.Router()
+	Logger.Info("start admin http server: ", a.server.Addr)
+	Logger.Info(a.server.ListenAndServe())
 }
 
-func (a *Agent) Members() []*memberlist.Node {
-	return a.cron.entries.list.Members()
-}
+func (a *Agent) close() {
+	a.cancel()
+	a.server.Close()
 
-func (a *Agent) validate(jobName string) error {
-	if jobName == "" {
-		return ErrJobNameEmpty
+	a.mu.Lock()
+	for _, n := range a.namespaces {
+		n.close()
 	}
-	if !a.executor.Contain(jobName) {
-		return ErrJobNotSupport
-	}
-	return nil
+	a.running = false
+	a.mu.Unlock()
+
+	a.storage.close()
+	a.entries.Close()
+	Logger.Info("agent shutdown gracefully")
+}
+
+// The following methods operate on the default namespace.
+
+func (a *Agent) Add(spec, jobName string) error { return a.defaultNamespace().Add(spec, jobName) }
+
+func (a *Agent) AddWithPlacement(spec, jobName string, p *Placement) error {
+	return a.defaultNamespace().AddWithPlacement(spec, jobName, p)
+}
+
+func (a *Agent) Active(jobName string) error { return a.defaultNamespace().Active(jobName) }
+
+func (a *Agent) Pause(jobName string) error { return a.defaultNamespace().Pause(jobName) }
+
+func (a *Agent) Remove(jobName string) error { return a.defaultNamespace().Remove(jobName) }
+
+func (a *Agent) ExecuteOnce(jobName string) error { return a.defaultNamespace().ExecuteOnce(jobName) }
+
+func (a *Agent) Schedule() ([]entryRecord, error) { return a.defaultNamespace().Schedule() }
+
+func (a *Agent) Running() ([]Execution, error) { return a.defaultNamespace().Running() }
+
+func (a *Agent) History(jobName string, offset, size int64) ([]Execution, int64, error) {
+	return a.defaultNamespace().History(jobName, offset, size)
 }
 
-type entryRecord struct {
-	Name      string `json:"name"`
-	Spec      string `json:"spec"`
-	Next      int64  `json:"next"`
-	Displayed bool   `json:"displayed"`
+func (a *Agent) Jobs() []string { return a.defaultNamespace().Jobs() }
+
+func (a *Agent) Members() []Member {
+	return a.entries.Members()
 }
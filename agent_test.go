@@ -0,0 +1,159 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// keySlot is the redis cluster slot of key: the crc16 of its hash tag, or of
+// the whole key if it has none
+func keySlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % 16384
+}
+
+func TestKeySlot(t *testing.T) {
+	// examples of the redis cluster specification
+	if slot := keySlot("123456789"); slot != 12739 {
+		t.Fatalf("slot %d, want 12739", slot)
+	}
+	if keySlot("{user1000}.following") != keySlot("{user1000}.followers") {
+		t.Fatal("keys of the same hash tag in different slots")
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	for _, ns := range []string{DefaultNamespace, "team", "team-b"} {
+		// the keys used together by the timeline, the execution store and
+		// the entry backup
+		for prefix, suffixes := range map[string][]string{
+			namespaceKey("_timeline", ns): {"", "_state"},
+			namespaceKey("_exe", ns):      {"_running", "_hist_job", "_5f0c3f56-8c41-4a52-9d1b-0b5d1d5a7f6e"},
+			"_entry":                      {"_job", "_team/job"},
+		} {
+			tagged := hashTag(prefix)
+			slot := keySlot(tagged)
+			for _, suffix := range suffixes {
+				if s := keySlot(tagged + suffix); s != slot {
+					t.Errorf("%s in slot %d, %s in slot %d", tagged+suffix, s, tagged, slot)
+				}
+			}
+		}
+	}
+
+	if tagged := hashTag("{cron}_timeline"); tagged != "{cron}_timeline" {
+		t.Fatalf("hash tag of a tagged key %q, want it unchanged", tagged)
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	conf := &Conf{}
+	conf.Base.RedisOptions.Addr = "127.0.0.1:6379"
+	cli := newRedisClient(conf)
+	defer cli.Close()
+	if _, ok := cli.(*redis.Client); !ok {
+		t.Fatalf("client %T, want a single node client", cli)
+	}
+
+	conf.Base.RedisCluster.Addrs = []string{"127.0.0.1:7000", "127.0.0.1:7001"}
+	cluster := newRedisClient(conf)
+	defer cluster.Close()
+	if _, ok := cluster.(*redis.ClusterClient); !ok {
+		t.Fatalf("client %T, want a cluster client", cluster)
+	}
+}
+
+func TestAgentRestoreReport(t *testing.T) {
+	var (
+		ctx = context.Background()
+		a   = newTestAgent(t)
+		n   = a.defaultNamespace()
+	)
+
+	for _, name := range []string{"ok", "missing", "corrupt"} {
+		if err := n.cron.timeline.Add(ctx, Event{Name: name, Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, spec := range map[string]string{"ok": testSpec, "corrupt": "not a spec"} {
+		if err := a.storage.backup.Save(ctx, &Entry{Name: name, Spec: spec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s := a.Status(); s.Restored || s.Restore[DefaultNamespace] != nil {
+		t.Fatalf("status %+v before restore", s)
+	}
+
+	a.custom.strictRestore = true
+	if err := a.restore(); err == nil {
+		t.Fatal("incomplete restore accepted in strict mode")
+	}
+
+	report := a.Status().Restore[DefaultNamespace]
+	if report == nil || report.Complete() {
+		t.Fatalf("report %+v, want incomplete", report)
+	}
+	if fmt.Sprint(report.Restored, report.Missing, report.Corrupt) != "[ok] [missing] [corrupt]" {
+		t.Fatalf("restored %v, missing %v, corrupt %v", report.Restored, report.Missing, report.Corrupt)
+	}
+	if _, ok := a.entries.Get("ok"); !ok {
+		t.Fatal("entry not restored")
+	}
+}
+
+func TestAgentRouterNotRunning(t *testing.T) {
+	a := newTestAgent(t)
+	h := a.Router()
+
+	code := func(path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		var resp struct{ Code int }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return resp.Code
+	}
+
+	// only the status is served until running
+	if c := code("/api/v1/status"); c != 0 {
+		t.Fatalf("status answered %d", c)
+	}
+	for _, path := range []string{"/api/v1/jobs", "/api/v1/add?spec=@every+1m&job=job", "/api/v1/keys"} {
+		if c := code(path); c != ErrCodeStarting {
+			t.Fatalf("%s answered %d before running, want %d", path, c, ErrCodeStarting)
+		}
+	}
+
+	a.mu.Lock()
+	a.running = true
+	a.mu.Unlock()
+	if c := code("/api/v1/jobs"); c != 0 {
+		t.Fatalf("jobs answered %d once running", c)
+	}
+}
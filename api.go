@@ -2,21 +2,29 @@ package cron
 
 import (
 	"encoding/json"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/yinyajun/cron-admin"
 )
 
 const (
-	ErrCodeAdd      = 1000
-	ErrCodeActive   = 1001
-	ErrCodePause    = 1002
-	ErrCodeRemove   = 1003
-	ErrCodeExecute  = 1004
-	ErrCodeSchedule = 1005
-	ErrCodeRunning  = 1006
-	ErrCodeHistory  = 1007
+	ErrCodeAdd       = 1000
+	ErrCodeActive    = 1001
+	ErrCodePause     = 1002
+	ErrCodeRemove    = 1003
+	ErrCodeExecute   = 1004
+	ErrCodeSchedule  = 1005
+	ErrCodeRunning   = 1006
+	ErrCodeHistory   = 1007
+	ErrCodeNamespace = 1008
+	ErrCodeChanges   = 1009
+	ErrCodeReconcile = 1010
+	ErrCodeKeys      = 1011
+	ErrCodeStarting  = 1012
 )
 
 func renderJson(w http.ResponseWriter, data interface{}) {
@@ -47,12 +55,51 @@ func renderErrJson(w http.ResponseWriter, code int, msg string) {
 	}
 }
 
+// namespaceOf resolves the namespace given by the "ns" query parameter, the
+// default namespace is used if absent.
+func namespaceOf(agent *Agent, w http.ResponseWriter, r *http.Request) (*Namespace, bool) {
+	ns, err := agent.lookup(r.URL.Query().Get("ns"))
+	if err != nil {
+		renderErrJson(w, ErrCodeNamespace, err.Error())
+		return nil, false
+	}
+	return ns, true
+}
+
+// parsePlacement parses the "node", "required" and "preferred" query
+// parameters, returns nil if none is given.
+func parsePlacement(query url.Values) (*Placement, error) {
+	required, err := ParseLabels(query.Get("required"))
+	if err != nil {
+		return nil, err
+	}
+	preferred, err := ParseLabels(query.Get("preferred"))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Placement{Node: query.Get("node"), Required: required, Preferred: preferred}
+	if p.Node == "" && len(p.Required) == 0 && len(p.Preferred) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
 func newAddHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
 		query := r.URL.Query()
 		spec := query.Get("spec")
 		job := query.Get("job")
-		if err := agent.Add(spec, job); err != nil {
+		placement, err := parsePlacement(query)
+		if err != nil {
+			renderErrJson(w, ErrCodeAdd, err.Error())
+			return
+		}
+		if err := ns.AddWithPlacement(spec, job, placement); err != nil {
 			renderErrJson(w, ErrCodeAdd, err.Error())
 			return
 		}
@@ -62,9 +109,13 @@ func newAddHandlerFunc(agent *Agent) http.HandlerFunc {
 
 func newActiveHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
 		query := r.URL.Query()
 		job := query.Get("job")
-		if err := agent.Active(job); err != nil {
+		if err := ns.Active(job); err != nil {
 			renderErrJson(w, ErrCodeActive, err.Error())
 			return
 		}
@@ -74,9 +125,13 @@ func newActiveHandlerFunc(agent *Agent) http.HandlerFunc {
 
 func newPauseHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
 		query := r.URL.Query()
 		job := query.Get("job")
-		if err := agent.Pause(job); err != nil {
+		if err := ns.Pause(job); err != nil {
 			renderErrJson(w, ErrCodePause, err.Error())
 			return
 		}
@@ -86,9 +141,13 @@ func newPauseHandlerFunc(agent *Agent) http.HandlerFunc {
 
 func newRemoveHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
 		query := r.URL.Query()
 		job := query.Get("job")
-		if err := agent.Remove(job); err != nil {
+		if err := ns.Remove(job); err != nil {
 			renderErrJson(w, ErrCodeRemove, err.Error())
 			return
 		}
@@ -98,9 +157,13 @@ func newRemoveHandlerFunc(agent *Agent) http.HandlerFunc {
 
 func newExecuteOnceHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
 		query := r.URL.Query()
 		job := query.Get("job")
-		if err := agent.ExecuteOnce(job); err != nil {
+		if err := ns.ExecuteOnce(job); err != nil {
 			renderErrJson(w, ErrCodeExecute, err.Error())
 			return
 		}
@@ -110,7 +173,11 @@ func newExecuteOnceHandlerFunc(agent *Agent) http.HandlerFunc {
 
 func newScheduleHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		events, err := agent.Schedule()
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
+		events, err := ns.Schedule()
 		if err != nil {
 			renderErrJson(w, ErrCodeSchedule, err.Error())
 			return
@@ -121,7 +188,11 @@ func newScheduleHandlerFunc(agent *Agent) http.HandlerFunc {
 
 func newRunningHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		executions, err := agent.Running()
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
+		executions, err := ns.Running()
 		if err != nil {
 			renderErrJson(w, ErrCodeRunning, err.Error())
 			return
@@ -132,11 +203,15 @@ func newRunningHandlerFunc(agent *Agent) http.HandlerFunc {
 
 func newHistoryHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
 		query := r.URL.Query()
 		job := query.Get("job")
 		offset, _ := strconv.ParseInt(query.Get("offset"), 10, 64)
 		size, _ := strconv.ParseInt(query.Get("size"), 10, 64)
-		executions, total, err := agent.History(job, offset, size)
+		executions, total, err := ns.History(job, offset, size)
 		if err != nil {
 			renderErrJson(w, ErrCodeHistory, err.Error())
 			return
@@ -148,9 +223,32 @@ func newHistoryHandlerFunc(agent *Agent) http.HandlerFunc {
 	}
 }
 
+func newChangesHandlerFunc(agent *Agent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
+		query := r.URL.Query()
+		after := query.Get("after")
+		count, _ := strconv.ParseInt(query.Get("count"), 10, 64)
+		block, _ := strconv.ParseInt(query.Get("block"), 10, 64)
+		changes, err := ns.Changes(after, count, time.Duration(block)*time.Millisecond)
+		if err != nil {
+			renderErrJson(w, ErrCodeChanges, err.Error())
+			return
+		}
+		renderJson(w, changes)
+	}
+}
+
 func newJobsHandlerFunc(agent *Agent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		renderJson(w, agent.Jobs())
+		ns, ok := namespaceOf(agent, w, r)
+		if !ok {
+			return
+		}
+		renderJson(w, ns.Jobs())
 	}
 }
 
@@ -160,6 +258,92 @@ func newMembersHandlerFunc(agent *Agent) http.HandlerFunc {
 	}
 }
 
+func newNamespacesHandlerFunc(agent *Agent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderJson(w, agent.Namespaces())
+	}
+}
+
+func newMemberEventsHandlerFunc(agent *Agent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderJson(w, agent.MemberEvents())
+	}
+}
+
+// newReconcileHandlerFunc renders the report of the last reconciliation, or
+// reconciles first with run=1.
+func newReconcileHandlerFunc(agent *Agent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := agent.reconciler.report()
+		if r.URL.Query().Get("run") == "1" {
+			report = agent.Reconcile()
+		}
+		if report.Err != "" {
+			renderErrJson(w, ErrCodeReconcile, report.Err)
+			return
+		}
+		renderJson(w, report)
+	}
+}
+
+func newStatusHandlerFunc(agent *Agent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderJson(w, agent.Status())
+	}
+}
+
+// keyRequest is the body of a key rotation
+type keyRequest struct {
+	Op  KeyOp  `json:"op"`
+	Key string `json:"key"` // base64
+}
+
+// newKeysHandlerFunc lists the gossip keys of the node, or rotates a key on
+// all the nodes with a POST of keyRequest. Keys are never read from the query,
+// which ends up in access logs. Rotation is disabled unless configured, and
+// requires a json body: browsers send it cross-origin only after a preflight
+// the api does not answer.
+func newKeysHandlerFunc(agent *Agent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			if !agent.keyRotation {
+				renderErrJson(w, ErrCodeKeys, ErrKeyRotationOff.Error())
+				return
+			}
+			if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+				renderErrJson(w, ErrCodeKeys, "keys are rotated by POST of application/json")
+				return
+			}
+
+			var req keyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				renderErrJson(w, ErrCodeKeys, err.Error())
+				return
+			}
+			if err := agent.RotateKey(req.Op, req.Key); err != nil {
+				renderErrJson(w, ErrCodeKeys, err.Error())
+				return
+			}
+		case http.MethodGet:
+			if r.URL.Query().Get("op") != "" {
+				renderErrJson(w, ErrCodeKeys, "keys are rotated by POST")
+				return
+			}
+		default:
+			renderErrJson(w, ErrCodeKeys, "method not allowed")
+			return
+		}
+
+		keys, err := agent.Keys()
+		if err != nil {
+			renderErrJson(w, ErrCodeKeys, err.Error())
+			return
+		}
+		renderJson(w, keys)
+	}
+}
+
 type GroupRouter struct {
 	prefix string
 	mux    *http.ServeMux
@@ -181,10 +365,40 @@ func (a *Agent) Router() http.Handler {
 	r.RegisterHandler("/running", newRunningHandlerFunc(a))
 	r.RegisterHandler("/schedule", newScheduleHandlerFunc(a))
 	r.RegisterHandler("/history", newHistoryHandlerFunc(a))
+	r.RegisterHandler("/changes", newChangesHandlerFunc(a))
 	r.RegisterHandler("/jobs", newJobsHandlerFunc(a))
 	r.RegisterHandler("/members", newMembersHandlerFunc(a))
+	r.RegisterHandler("/member_events", newMemberEventsHandlerFunc(a))
+	r.RegisterHandler("/namespaces", newNamespacesHandlerFunc(a))
+	r.RegisterHandler("/reconcile", newReconcileHandlerFunc(a))
+	r.RegisterHandler("/status", newStatusHandlerFunc(a))
+	r.RegisterHandler("/keys", newKeysHandlerFunc(a))
 
 	mux.Handle("/", admin.UIHandler())
 
-	return mux
+	return a.whenRunning(mux, "/api/v1/status")
+}
+
+// whenRunning serves only the given paths until the agent runs: the
+// entries are not restored yet, and the changes would wait for the
+// scheduler to apply them.
+func (a *Agent) whenRunning(h http.Handler, paths ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range paths {
+			if r.URL.Path == path {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		a.mu.Lock()
+		running := a.running
+		a.mu.Unlock()
+
+		if !running {
+			renderErrJson(w, ErrCodeStarting, ErrNotRunning.Error())
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
 }
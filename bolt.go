@@ -0,0 +1,437 @@
+package cron
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The bolt backends keep all the state in one embedded file, so a single
+// node can run and survive restarts without any external dependency.
+
+type boltTimeline struct {
+	db *bolt.DB
+
+	events   []byte // name -> ts + displayed
+	schedule []byte // ts + name of displayed events, ordered by time
+}
+
+func NewBoltTimeline(db *bolt.DB, bucket string) Timeline {
+	t := &boltTimeline{
+		db:       db,
+		events:   []byte(bucket),
+		schedule: []byte(bucket + "_schedule"),
+	}
+
+	mustCreateBuckets(db, t.events, t.schedule)
+	return t
+}
+
+func (b *boltTimeline) Add(ctx context.Context, event Event) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return b.put(tx, event)
+	})
+}
+
+func (b *boltTimeline) Remove(ctx context.Context, name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		old, ok := b.get(tx, name)
+		if !ok {
+			return nil
+		}
+		if err := tx.Bucket(b.schedule).Delete(scheduleKey(old)); err != nil {
+			return err
+		}
+		return tx.Bucket(b.events).Delete([]byte(name))
+	})
+}
+
+func (b *boltTimeline) Hide(ctx context.Context, name string) error {
+	return b.setDisplayed(ctx, name, false)
+}
+
+func (b *boltTimeline) Display(ctx context.Context, name string) error {
+	return b.setDisplayed(ctx, name, true)
+}
+
+func (b *boltTimeline) setDisplayed(ctx context.Context, name string, displayed bool) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		event, ok := b.get(tx, name)
+		if !ok {
+			return ErrEventNotFound
+		}
+		if event.Displayed == displayed {
+			return nil
+		}
+
+		event.Displayed = displayed
+		return b.put(tx, event)
+	})
+}
+
+func (b *boltTimeline) TryModify(ctx context.Context, event Event, t time.Time) (bool, error) {
+	var ok bool
+
+	err := b.db.Update(func(tx *bolt.Tx) (err error) {
+		ok, err = b.modify(tx, event, t)
+		return err
+	})
+	return ok, err
+}
+
+func (b *boltTimeline) TryModifyBatch(ctx context.Context, claims []Claim) ([]Event, error) {
+	var events []Event
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, c := range claims {
+			ok, err := b.modify(tx, c.Event, c.Next)
+			if err != nil {
+				return err
+			}
+			if ok {
+				events = append(events, c.Event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (b *boltTimeline) modify(tx *bolt.Tx, event Event, t time.Time) (bool, error) {
+	cur, ok := b.get(tx, event.Name)
+	if !ok || cur.Time.Unix() != event.Time.Unix() || cur.Displayed != event.Displayed {
+		return false, nil
+	}
+
+	cur.Time = t
+	return true, b.put(tx, cur)
+}
+
+func (b *boltTimeline) Find(ctx context.Context, name string) (Event, error) {
+	var event Event
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		event, _ = b.get(tx, name)
+		return nil
+	})
+	return event, err
+}
+
+func (b *boltTimeline) FindEarliest(ctx context.Context) (Event, error) {
+	var event Event
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(b.schedule).Cursor().First()
+		if k != nil {
+			event = parseScheduleKey(k)
+		}
+		return nil
+	})
+	return event, err
+}
+
+func (b *boltTimeline) FetchHistory(ctx context.Context, t time.Time, after Event, limit int64) ([]Event, error) {
+	var events = make([]Event, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(b.schedule).Cursor()
+		for k, _ := c.Seek(scheduleKey(after)); k != nil; k, _ = c.Next() {
+			event := parseScheduleKey(k)
+			if event.Time.Unix() > t.Unix() || (limit > 0 && int64(len(events)) == limit) {
+				break
+			}
+			if eventAfter(event, after) {
+				events = append(events, event)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (b *boltTimeline) Events(ctx context.Context) ([]Event, error) {
+	var events = make([]Event, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.events).ForEach(func(k, v []byte) error {
+			events = append(events, decodeEvent(string(k), v))
+			return nil
+		})
+	})
+	sortEvents(events)
+	return events, err
+}
+
+func (b *boltTimeline) Close() {}
+
+func (b *boltTimeline) get(tx *bolt.Tx, name string) (Event, bool) {
+	v := tx.Bucket(b.events).Get([]byte(name))
+	if v == nil {
+		return Event{}, false
+	}
+	return decodeEvent(name, v), true
+}
+
+func (b *boltTimeline) put(tx *bolt.Tx, event Event) error {
+	schedule := tx.Bucket(b.schedule)
+
+	if old, ok := b.get(tx, event.Name); ok {
+		if err := schedule.Delete(scheduleKey(old)); err != nil {
+			return err
+		}
+	}
+	if event.Displayed {
+		if err := schedule.Put(scheduleKey(event), nil); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(b.events).Put([]byte(event.Name), encodeEvent(event))
+}
+
+func encodeEvent(e Event) []byte {
+	v := make([]byte, 9)
+	binary.BigEndian.PutUint64(v, uint64(e.Time.Unix()))
+	if e.Displayed {
+		v[8] = 1
+	}
+	return v
+}
+
+func decodeEvent(name string, v []byte) Event {
+	return Event{
+		Name:      name,
+		Time:      time.Unix(int64(binary.BigEndian.Uint64(v)), 0),
+		Displayed: v[8] == 1,
+	}
+}
+
+// scheduleKey orders events by time and then by name, the sign bit is
+// flipped so negative timestamps are ordered correctly.
+func scheduleKey(e Event) []byte {
+	k := make([]byte, 8, 8+len(e.Name))
+	binary.BigEndian.PutUint64(k, uint64(e.Time.Unix())^(1<<63))
+	return append(k, e.Name...)
+}
+
+func parseScheduleKey(k []byte) Event {
+	return Event{
+		Name:      string(k[8:]),
+		Time:      time.Unix(int64(binary.BigEndian.Uint64(k)^(1<<63)), 0),
+		Displayed: true,
+	}
+}
+
+type boltEntryBackup struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func NewBoltEntryBackup(db *bolt.DB, bucket string) EntryBackup {
+	b := &boltEntryBackup{
+		db:     db,
+		bucket: []byte(bucket),
+	}
+
+	mustCreateBuckets(db, b.bucket)
+	return b
+}
+
+func (b *boltEntryBackup) Save(ctx context.Context, e *Entry) error {
+	ser, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).Put([]byte(e.Key()), ser)
+	})
+}
+
+func (b *boltEntryBackup) Delete(ctx context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).Delete([]byte(key))
+	})
+}
+
+func (b *boltEntryBackup) Load(ctx context.Context, key string) (*Entry, error) {
+	var e *Entry
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		ser := tx.Bucket(b.bucket).Get([]byte(key))
+		if ser == nil {
+			return ErrEntryNotFound
+		}
+
+		var err error
+		e, err = decodeEntry(ser)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (b *boltEntryBackup) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+type boltExecutionStore struct {
+	db *bolt.DB
+
+	executions []byte // id -> execution
+	running    []byte // id of running executions
+	history    []byte // one nested bucket per job, started_at + id -> id
+
+	maxHistoryNum int64
+}
+
+func NewBoltExecutionStore(db *bolt.DB, bucket string, maxHistoryNum int64) ExecutionStore {
+	b := &boltExecutionStore{
+		db:            db,
+		executions:    []byte(bucket),
+		running:       []byte(bucket + "_running"),
+		history:       []byte(bucket + "_hist"),
+		maxHistoryNum: maxHistoryNum,
+	}
+
+	mustCreateBuckets(db, b.executions, b.running, b.history)
+	return b
+}
+
+func (b *boltExecutionStore) Begin(ctx context.Context, e *Execution) error {
+	ser, _ := json.Marshal(e)
+	id := []byte(e.ID.String())
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(b.executions).Put(id, ser); err != nil {
+			return err
+		}
+		if err := tx.Bucket(b.running).Put(id, []byte{1}); err != nil {
+			return err
+		}
+
+		history, err := tx.Bucket(b.history).CreateBucketIfNotExists([]byte(e.Name))
+		if err != nil {
+			return err
+		}
+
+		k := make([]byte, 8, 8+len(id))
+		binary.BigEndian.PutUint64(k, uint64(e.StartedAt))
+		if err := history.Put(append(k, id...), id); err != nil {
+			return err
+		}
+
+		// keep the latest maxHistoryNum executions of the job
+		var (
+			n       int64
+			expired [][]byte
+		)
+		c := history.Cursor()
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			if n++; n > b.maxHistoryNum {
+				expired = append(expired, k)
+			}
+		}
+		for _, k := range expired {
+			id := history.Get(k)
+			if tx.Bucket(b.running).Get(id) == nil {
+				if err := tx.Bucket(b.executions).Delete(id); err != nil {
+					return err
+				}
+			}
+			if err := history.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltExecutionStore) Finish(ctx context.Context, e *Execution) error {
+	ser, _ := json.Marshal(e)
+	id := []byte(e.ID.String())
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(b.executions).Put(id, ser); err != nil {
+			return err
+		}
+		return tx.Bucket(b.running).Delete(id)
+	})
+}
+
+func (b *boltExecutionStore) Running(ctx context.Context) ([]Execution, error) {
+	var executions = make([]Execution, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.running).ForEach(func(id, _ []byte) error {
+			executions = b.appendExecution(tx, executions, id)
+			return nil
+		})
+	})
+	return executions, err
+}
+
+func (b *boltExecutionStore) History(ctx context.Context, jobName string, offset, size int64) ([]Execution, error) {
+	var executions = make([]Execution, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(b.history).Bucket([]byte(jobName))
+		if history == nil {
+			return nil
+		}
+
+		var i int64
+		c := history.Cursor()
+		for k, id := c.Last(); k != nil && i < offset+size; k, id = c.Prev() {
+			if i++; i > offset {
+				executions = b.appendExecution(tx, executions, id)
+			}
+		}
+		return nil
+	})
+	return executions, err
+}
+
+func (b *boltExecutionStore) appendExecution(tx *bolt.Tx, executions []Execution, id []byte) []Execution {
+	ser := tx.Bucket(b.executions).Get(id)
+	if ser == nil {
+		Logger.Warn("fetchExecutions err", string(id))
+		return executions
+	}
+
+	var execution Execution
+	if err := json.Unmarshal(ser, &execution); err != nil {
+		Logger.Warn("fetchExecutions err", string(id))
+		return executions
+	}
+	return append(executions, execution)
+}
+
+func mustCreateBuckets(db *bolt.DB, buckets ...[]byte) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		Logger.Fatalln(err)
+	}
+}
@@ -0,0 +1,28 @@
+package cron_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/yinyajun/cron"
+)
+
+// openBolt opens a bolt database in a temporary file closed with the test
+func openBolt(t *testing.T) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "cron.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBoltEntryBackup(t *testing.T) {
+	testEntryBackup(t, cron.NewBoltEntryBackup(openBolt(t), "_entry"))
+}
+
+func TestBoltExecutionStore(t *testing.T) {
+	testExecutionStore(t, cron.NewBoltExecutionStore(openBolt(t), "_exe", 3))
+}
@@ -1,16 +1,26 @@
 package cron
 
 import (
+	"context"
 	"encoding/json"
+	"sync"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/robfig/cron/v3"
 )
 
+// DefaultNamespace is the namespace of the entries added without one
+const DefaultNamespace = "default"
+
 type Entry struct {
-	Name    string `json:"node"`
-	Spec    string `json:"spec"`
-	Deleted bool   `json:"deleted,omitempty"`
+	Namespace string  `json:"namespace,omitempty"`
+	Name      string  `json:"node"`
+	Spec      string  `json:"spec"`
+	Deleted   bool    `json:"deleted,omitempty"`
+	Version   Version `json:"version"`
+
+	Placement *Placement `json:"placement,omitempty"`
 
 	schedule cron.Schedule
 }
@@ -20,9 +30,57 @@ func (e Entry) String() string {
 	return string(ser)
 }
 
+// Key identifies the entry among all the namespaces
+func (e Entry) Key() string { return entryKey(e.Namespace, e.Name) }
+
+// legacy reports whether e is known to the nodes of gossip protocol 0, the
+// entries of the default namespace
+func (e Entry) legacy() bool { return e.Namespace == "" || e.Namespace == DefaultNamespace }
+
+func (e *Entry) normalize() {
+	if e.Namespace == "" {
+		e.Namespace = DefaultNamespace
+	}
+}
+
+// entryKey keeps the keys of the default namespace as they were before
+// namespaces, so the existing backups are still restored.
+func entryKey(namespace, name string) string {
+	if namespace == "" || namespace == DefaultNamespace {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// ClaimPolicy decides which nodes compete for an expired entry
+type ClaimPolicy interface {
+	// Eligible reports whether the local node may run the entry, and
+	// whether any alive node may run it
+	Eligible(e Entry) (local, any bool)
+	// Reject is called with the claimed entries no alive node may run
+	Reject(e Entry)
+}
+
+// skipBackoff delays the next fetch after expired events are left to other
+// nodes, or skipped without entry, so the run loop does not spin until they
+// are claimed or the entry is known.
+const skipBackoff = time.Second
+
 type Cron struct {
-	entries  *Entries
-	timeline Timeline
+	entries   EntryStore
+	timeline  Timeline
+	policy    ClaimPolicy
+	namespace string
+	pageSize  int64
+	timeout   time.Duration
+
+	restoreOnce sync.Once
+	restoreMu   sync.Mutex
+	restored    *RestoreReport
+
+	// ctx is canceled on close, aborting the pending storage calls
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	actionCh    chan Action
 	executionCh chan<- string
@@ -30,14 +88,19 @@ type Cron struct {
 }
 
 func NewCron(
-	entries *Entries,
+	entries EntryStore,
 	timeline Timeline,
 	result chan<- string) *Cron {
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	c := &Cron{
 		entries:  entries,
 		timeline: timeline,
 
+		ctx:    ctx,
+		cancel: cancel,
+
 		actionCh:    make(chan Action),
 		executionCh: result,
 		stop:        make(chan struct{}),
@@ -50,7 +113,30 @@ func NewCron(
 	return c
 }
 
-func (c *Cron) Add(spec string, name string) error {
+// WithNamespace scopes the cron to the entries of namespace, the timeline
+// should be dedicated to the namespace as well.
+func (c *Cron) WithNamespace(namespace string) { c.namespace = namespace }
+
+// WithClaimPolicy makes the cron claim only the entries eligible by p,
+// every node competes for every entry by default.
+func (c *Cron) WithClaimPolicy(p ClaimPolicy) { c.policy = p }
+
+// WithPageSize limits the number of expired events handled at a time, so a
+// large backlog does not hold up the run loop.
+func (c *Cron) WithPageSize(n int64) { c.pageSize = n }
+
+// WithTimeout limits the duration of every storage call.
+func (c *Cron) WithTimeout(d time.Duration) { c.timeout = d }
+
+func (c *Cron) Add(ctx context.Context, spec string, name string) error {
+	return c.AddWithPlacement(ctx, spec, name, nil)
+}
+
+// AddWithPlacement adds an entry run only on the nodes allowed by p
+func (c *Cron) AddWithPlacement(ctx context.Context, spec string, name string, p *Placement) error {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
 	schedule, err := parseSchedule(spec)
 	if err != nil {
 		return err
@@ -63,15 +149,22 @@ func (c *Cron) Add(spec string, name string) error {
 	}
 
 	action := Action{
-		Type:  addType,
-		Entry: &Entry{Name: name, Spec: spec, schedule: schedule},
+		Type: addType,
+		Entry: &Entry{
+			Namespace: c.namespace,
+			Name:      name,
+			Spec:      spec,
+			Version:   c.entries.NewVersion(),
+			Placement: p,
+			schedule:  schedule,
+		},
 	}
 
-	if err := c.entries.Backup(action); err != nil {
+	if err := c.entries.Backup(ctx, action); err != nil {
 		return err
 	}
 
-	if err := c.timeline.Add(event); err != nil {
+	if err := c.timeline.Add(ctx, event); err != nil {
 		return err
 	}
 
@@ -80,17 +173,25 @@ func (c *Cron) Add(spec string, name string) error {
 	return nil
 }
 
-func (c *Cron) Remove(name string) error {
-	if err := c.timeline.Remove(name); err != nil {
+func (c *Cron) Remove(ctx context.Context, name string) error {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if err := c.timeline.Remove(ctx, name); err != nil {
 		return err
 	}
 
 	action := Action{
-		Type:  removeType,
-		Entry: &Entry{Name: name},
+		Type: removeType,
+		Entry: &Entry{
+			Namespace: c.namespace,
+			Name:      name,
+			Deleted:   true,
+			Version:   c.entries.NewVersion(),
+		},
 	}
 
-	if err := c.entries.Backup(action); err != nil {
+	if err := c.entries.Backup(ctx, action); err != nil {
 		return err
 	}
 	c.actionCh <- action
@@ -98,62 +199,129 @@ func (c *Cron) Remove(name string) error {
 	return nil
 }
 
-func (c *Cron) Pause(name string) error {
-	if err := c.timeline.Hide(name); err != nil {
+func (c *Cron) Pause(ctx context.Context, name string) error {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if err := c.timeline.Hide(ctx, name); err != nil {
 		return err
 	}
 	Logger.Info("pause:", name)
 	return nil
 }
 
-func (c *Cron) Activate(name string) error {
-	if err := c.timeline.Display(name); err != nil {
+func (c *Cron) Activate(ctx context.Context, name string) error {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if err := c.timeline.Display(ctx, name); err != nil {
 		return err
 	}
 	Logger.Info("activate:", name)
 	return nil
 }
 
-func (c *Cron) Events() ([]Event, error) { return c.timeline.Events() }
+func (c *Cron) Events(ctx context.Context) ([]Event, error) {
+	ctx, cancel := withTimeout(ctx, c.timeout)
+	defer cancel()
+
+	return c.timeline.Events(ctx)
+}
+
+func (c *Cron) close() {
+	c.cancel()
+	c.stop <- struct{}{}
+}
+
+// Restore loads the entries of the timeline from backup, it is called by
+// run unless called before.
+func (c *Cron) Restore() RestoreReport {
+	c.restoreOnce.Do(func() {
+		report := c.restore()
 
-func (c *Cron) close() { c.stop <- struct{}{} }
+		c.restoreMu.Lock()
+		c.restored = &report
+		c.restoreMu.Unlock()
+	})
 
-func (c *Cron) restore() {
-	events, err := c.timeline.Events()
+	report, _ := c.Restored()
+	return report
+}
+
+// Restored returns the report of Restore, false if not restored yet
+func (c *Cron) Restored() (RestoreReport, bool) {
+	c.restoreMu.Lock()
+	defer c.restoreMu.Unlock()
+
+	if c.restored == nil {
+		return RestoreReport{}, false
+	}
+	return *c.restored, true
+}
+
+func (c *Cron) restore() RestoreReport {
+	events, err := c.Events(c.ctx)
 	if err != nil {
 		Logger.Error("restore ", err)
+		return RestoreReport{Time: time.Now().UnixNano() / int64(time.Millisecond), Err: err.Error()}
 	}
 
-	names := make([]string, len(events))
-	for i := 0; i < len(names); i++ {
-		names[i] = events[i].Name
+	keys := make([]string, len(events))
+	for i := 0; i < len(keys); i++ {
+		keys[i] = entryKey(c.namespace, events[i].Name)
 	}
 
-	if err := c.entries.Restore(names); err != nil {
+	// the entries are loaded in batches, each bounded by the timeout
+	report, err := c.entries.Restore(c.ctx, keys)
+	if err != nil {
 		Logger.Error("restore ", err)
 	}
-	Logger.Infof("restore %d events from timeline", len(events))
+	Logger.Infof("restore %d events from timeline of namespace %s: %d restored, %d missing, %d corrupt",
+		len(events), c.namespace, len(report.Restored), len(report.Missing), len(report.Corrupt))
+	return report
 }
 
 func (c *Cron) run() {
-	c.restore()
+	c.Restore()
 
-	var timer *time.Timer
-	now := time.Now()
+	var (
+		timer  *time.Timer
+		now    = time.Now()
+		cursor Event // last handled event while draining the backlog
+		skip   bool  // expired events are left to other nodes or skipped
+	)
 
 	for {
-		if e, err := c.timeline.FindEarliest(); err != nil || e.IsEmpty() {
+		if !cursor.IsEmpty() {
+			timer = time.NewTimer(0)
+		} else if e, err := c.findEarliest(); err != nil || e.IsEmpty() {
 			timer = time.NewTimer(5 * time.Second)
 		} else {
-			timer = time.NewTimer(e.Time.Sub(now))
+			wait := e.Time.Sub(now)
+			// give the eligible nodes time to claim the events left to them
+			if skip && wait < skipBackoff {
+				wait = skipBackoff
+			}
+			timer = time.NewTimer(wait)
 		}
 
 		for {
 			select {
-			case now = <-timer.C:
-				if err := c.doExpired(now); err != nil {
+			case t := <-timer.C:
+				// pages of one backlog are fetched at the same time
+				if cursor.IsEmpty() {
+					now = t
+					skip = false
+				}
+
+				var (
+					skipped bool
+					err     error
+				)
+				if cursor, skipped, err = c.doExpired(now, cursor); err != nil {
 					Logger.Error("run failed: ", err.Error())
 				}
+				skip = skip || skipped
 
 			case action := <-c.actionCh:
 				timer.Stop()
@@ -164,9 +332,9 @@ func (c *Cron) run() {
 					c.entries.Broadcast(action)
 					Logger.Info("add: ", action.Entry)
 				case removeType:
-					c.entries.Remove(action.Entry.Name)
+					c.entries.Remove(action.Entry.Key(), action.Entry.Version)
 					c.entries.Broadcast(action)
-					Logger.Info("remove: ", action.Entry.Name)
+					Logger.Info("remove: ", action.Entry.Key())
 				}
 			case <-c.stop:
 				timer.Stop()
@@ -179,17 +347,58 @@ func (c *Cron) run() {
 	}
 }
 
-func (c *Cron) doExpired(now time.Time) error {
-	expiredEvents, err := c.timeline.FetchHistory(now)
+func (c *Cron) findEarliest() (Event, error) {
+	ctx, cancel := withTimeout(c.ctx, c.timeout)
+	defer cancel()
+
+	return c.timeline.FindEarliest(ctx)
+}
+
+// doExpired dispenses one page of the events expired at now after cursor,
+// returns the cursor of the next page, or an empty event if drained, and
+// whether some events are left to other nodes or skipped.
+func (c *Cron) doExpired(now time.Time, cursor Event) (Event, bool, error) {
+	ctx, cancel := withTimeout(c.ctx, c.timeout)
+	defer cancel()
+
+	expiredEvents, err := c.timeline.FetchHistory(ctx, now, cursor, c.pageSize)
 	if err != nil {
-		return err
+		return Event{}, false, err
 	}
 
+	var (
+		claims   = make([]Claim, 0, len(expiredEvents))
+		rejected = make(map[string]Entry)
+		skip     bool
+	)
+
 	for _, event := range expiredEvents {
-		entry, ok := c.entries.Get(event.Name)
+		// the entry may not be synced yet, or being added or removed: the
+		// event is left in the timeline, reported by the reconciler, and
+		// fetched again after the backoff
+		entry, ok := c.entries.Get(entryKey(c.namespace, event.Name))
 		if !ok {
+			Logger.Debugf("[%s] expired without entry, skipped", entryKey(c.namespace, event.Name))
+			metrics.IncrCounter([]string{"cron", "expired", "missing_entry"}, 1)
+			skip = true
 			continue
 		}
+		if entry.Deleted || entry.schedule == nil {
+			skip = true
+			continue
+		}
+
+		if c.policy != nil {
+			local, any := c.policy.Eligible(entry)
+			if !local && any {
+				skip = true
+				continue
+			}
+			// nobody can run it, claim it to record the failure
+			if !local {
+				rejected[event.Name] = entry
+			}
+		}
 
 		next := entry.schedule.Next(event.Time)
 		// entry expires long ago
@@ -197,17 +406,28 @@ func (c *Cron) doExpired(now time.Time) error {
 			next = entry.schedule.Next(now)
 		}
 
-		tryOK, err := c.timeline.TryModify(event, next)
-		if err != nil {
-			Logger.Error("dispense failed: ", err.Error())
+		claims = append(claims, Claim{Event: event, Next: next})
+	}
+
+	claimed, err := c.timeline.TryModifyBatch(ctx, claims)
+	if err != nil {
+		return Event{}, false, err
+	}
+
+	for _, event := range claimed {
+		if entry, ok := rejected[event.Name]; ok {
+			c.policy.Reject(entry)
 			continue
 		}
-		if tryOK {
-			c.executionCh <- event.Name
-			Logger.Info("dispense: ", entry)
-		}
+
+		c.executionCh <- event.Name
+		Logger.Info("dispense: ", event.Name)
 	}
-	return nil
+
+	if c.pageSize > 0 && int64(len(expiredEvents)) == c.pageSize {
+		return expiredEvents[len(expiredEvents)-1], skip, nil
+	}
+	return Event{}, skip, nil
 }
 
 func parseSchedule(spec string) (cron.Schedule, error) {
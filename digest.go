@@ -0,0 +1,246 @@
+package cron
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+var ErrStateInvalid = errors.New("invalid gossip state")
+
+// The push/pull state is a digest of the entries: their keys and versions,
+// without spec nor placement. Each node sends the other one only the entries
+// it has newer, in batches of entries. Both are framed, see protocol.go.
+
+// entryBatchSize is the number of entries sent in one message
+const entryBatchSize = 1000
+
+// digest is the state of a node exchanged by push/pull
+type digest struct {
+	Node    string
+	Entries map[string]digestEntry
+}
+
+type digestEntry struct {
+	Version Version
+	Deleted bool
+}
+
+// digest encodes the digest of the local entries, node is the local node
+// the other nodes send their newer entries to.
+//
+// body: node, the node names versions are stamped by, then every entry as
+// key, wall, logical, index of the node name and deleted.
+func (s *entrySet) digest(node string) []byte {
+	var (
+		body  bytes.Buffer
+		names = make(map[string]uint64)
+		order []string
+	)
+
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.local))
+	for key, e := range s.local {
+		keys = append(keys, key)
+		if _, ok := names[e.Version.Node]; !ok {
+			names[e.Version.Node] = uint64(len(order))
+			order = append(order, e.Version.Node)
+		}
+	}
+	// sorted keys share prefixes, which compress better
+	sort.Strings(keys)
+
+	writeString(&body, node)
+	writeUvarint(&body, uint64(len(order)))
+	for _, name := range order {
+		writeString(&body, name)
+	}
+	writeUvarint(&body, uint64(len(keys)))
+	for _, key := range keys {
+		e := s.local[key]
+		writeString(&body, key)
+		writeVarint(&body, e.Version.Wall)
+		writeVarint(&body, e.Version.Logical)
+		writeUvarint(&body, names[e.Version.Node])
+		if e.Deleted {
+			body.WriteByte(1)
+		} else {
+			body.WriteByte(0)
+		}
+	}
+	s.mu.RUnlock()
+
+	return frame(kindDigest, body.Bytes())
+}
+
+func decodeDigest(r *bytes.Reader) (*digest, error) {
+	var (
+		d   = &digest{}
+		err error
+	)
+	if d.Node, err = readString(r); err != nil {
+		return nil, err
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, ErrStateInvalid
+	}
+	names := make([]string, 0, n)
+	for i := uint64(0); i < n; i++ {
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	if n, err = binary.ReadUvarint(r); err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, ErrStateInvalid
+	}
+	d.Entries = make(map[string]digestEntry, n)
+	for i := uint64(0); i < n; i++ {
+		var (
+			key string
+			e   digestEntry
+			idx uint64
+			del byte
+		)
+		if key, err = readString(r); err != nil {
+			return nil, err
+		}
+		if e.Version.Wall, err = binary.ReadVarint(r); err != nil {
+			return nil, err
+		}
+		if e.Version.Logical, err = binary.ReadVarint(r); err != nil {
+			return nil, err
+		}
+		if idx, err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+		if idx >= uint64(len(names)) {
+			return nil, ErrStateInvalid
+		}
+		if del, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		e.Version.Node, e.Deleted = names[idx], del == 1
+		d.Entries[key] = e
+	}
+	return d, nil
+}
+
+// newerThan lists the local entries the node of d lacks or has older,
+// expired tombstones are left out.
+func (s *entrySet) newerThan(d *digest) []*Entry {
+	var (
+		entries []*Entry
+		ttl     = s.ttl()
+		now     = time.Now()
+	)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for key, e := range s.local {
+		if s.expired(e, ttl, now) {
+			continue
+		}
+		r, ok := d.Entries[key]
+		if ok && !newer(e, &Entry{Version: r.Version, Deleted: r.Deleted}) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// encodeEntries encodes entries in batches of entryBatchSize
+func encodeEntries(entries []*Entry) [][]byte {
+	var msgs [][]byte
+
+	for start := 0; start < len(entries); start += entryBatchSize {
+		end := start + entryBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		var body bytes.Buffer
+		writeUvarint(&body, uint64(end-start))
+		for _, e := range entries[start:end] {
+			ser, _ := json.Marshal(e)
+			writeUvarint(&body, uint64(len(ser)))
+			body.Write(ser)
+		}
+		msgs = append(msgs, frame(kindEntries, body.Bytes()))
+	}
+	return msgs
+}
+
+// mergeEntries merges a batch of entries sent by a remote node
+func (s *entrySet) mergeEntries(r *bytes.Reader, from string) error {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if size > uint64(r.Len()) {
+			return ErrStateInvalid
+		}
+		ser := make([]byte, size)
+		if _, err := io.ReadFull(r, ser); err != nil {
+			return err
+		}
+
+		e := &Entry{}
+		if err := json.Unmarshal(ser, e); err != nil {
+			return err
+		}
+		s.mergeEntry(e, from)
+	}
+	return nil
+}
+
+func writeUvarint(b *bytes.Buffer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func writeVarint(b *bytes.Buffer, v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutVarint(buf[:], v)])
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	writeUvarint(b, uint64(len(s)))
+	b.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", ErrStateInvalid
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,150 @@
+package cron
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// fillEntrySet adds n entries to s, stamped by s
+func fillEntrySet(s *entrySet, n int) {
+	for i := 0; i < n; i++ {
+		s.Add(&Entry{Namespace: "team", Name: fmt.Sprintf("job-%06d", i), Spec: "@every 1m", Version: s.NewVersion()})
+	}
+}
+
+// copyEntrySet adds the entries of from to s
+func copyEntrySet(s, from *entrySet) {
+	for _, e := range from.Entries() {
+		e := e
+		s.Add(&e)
+	}
+}
+
+func decodeTestDigest(tb testing.TB, b []byte) *digest {
+	kind, r, err := unframe(b)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if kind != kindDigest {
+		tb.Fatalf("kind %d, want digest", kind)
+	}
+	d, err := decodeDigest(r)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return d
+}
+
+func TestDigestRoundTrip(t *testing.T) {
+	s := newTestEntrySet(t, "a")
+	s.Add(&Entry{Name: "x", Spec: testSpec, Version: s.NewVersion()})
+	s.Add(&Entry{Namespace: "team", Name: "y", Spec: testSpec, Version: Version{Wall: 1000, Logical: 2, Node: "b"}})
+	s.Add(&Entry{Name: "z", Spec: testSpec})
+	s.Remove("x", s.NewVersion())
+
+	d := decodeTestDigest(t, s.digest("a"))
+	if d.Node != "a" {
+		t.Fatalf("node %q, want a", d.Node)
+	}
+
+	entries := s.Entries()
+	if len(d.Entries) != len(entries) {
+		t.Fatalf("%d entries in digest, want %d", len(d.Entries), len(entries))
+	}
+	for key, e := range entries {
+		r, ok := d.Entries[key]
+		if !ok || r.Version != e.Version || r.Deleted != e.Deleted {
+			t.Fatalf("%s: %+v in digest, want %+v", key, r, e)
+		}
+	}
+}
+
+func TestDigestNewerThan(t *testing.T) {
+	a, b := newTestEntrySet(t, "a"), newTestEntrySet(t, "b")
+	fillEntrySet(a, 100)
+	copyEntrySet(b, a)
+
+	// b changes, adds and removes entries, a only changes one of its own
+	b.Add(&Entry{Namespace: "team", Name: "job-000001", Spec: testSpec, Version: b.NewVersion()})
+	b.Add(&Entry{Namespace: "team", Name: "new", Spec: testSpec, Version: b.NewVersion()})
+	b.Remove("team/job-000002", b.NewVersion())
+	a.Add(&Entry{Namespace: "team", Name: "job-000003", Spec: testSpec, Version: a.NewVersion()})
+
+	var keys []string
+	for _, e := range b.newerThan(decodeTestDigest(t, a.digest("a"))) {
+		keys = append(keys, e.Key())
+	}
+	sort.Strings(keys)
+
+	want := []string{"team/job-000001", "team/job-000002", "team/new"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("newer entries %v, want %v", keys, want)
+	}
+
+	// once merged, a has nothing older
+	for _, msg := range encodeEntries(b.newerThan(decodeTestDigest(t, a.digest("a")))) {
+		_, r, err := unframe(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.mergeEntries(r, "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries := b.newerThan(decodeTestDigest(t, a.digest("a"))); len(entries) != 0 {
+		t.Fatalf("%d newer entries after merge", len(entries))
+	}
+}
+
+func benchmarkDigest(b *testing.B, n int) {
+	s := newEntrySet(NewMemoryEntryBackup(), "a")
+	defer s.close()
+	fillEntrySet(s, n)
+
+	var size int
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		size = len(s.digest("a"))
+	}
+	b.ReportMetric(float64(size), "bytes")
+}
+
+func BenchmarkDigest10k(b *testing.B)  { benchmarkDigest(b, 10000) }
+func BenchmarkDigest100k(b *testing.B) { benchmarkDigest(b, 100000) }
+
+func benchmarkDecodeDigest(b *testing.B, n int) {
+	s := newEntrySet(NewMemoryEntryBackup(), "a")
+	defer s.close()
+	fillEntrySet(s, n)
+	msg := s.digest("a")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeTestDigest(b, msg)
+	}
+}
+
+func BenchmarkDecodeDigest10k(b *testing.B)  { benchmarkDecodeDigest(b, 10000) }
+func BenchmarkDecodeDigest100k(b *testing.B) { benchmarkDecodeDigest(b, 100000) }
+
+// benchmarkMergeDigest merges the digest of a node in sync, the common case
+// of push/pull: decoding it and finding no newer entry.
+func benchmarkMergeDigest(b *testing.B, n int) {
+	x, y := newEntrySet(NewMemoryEntryBackup(), "x"), newEntrySet(NewMemoryEntryBackup(), "y")
+	defer x.close()
+	defer y.close()
+	fillEntrySet(x, n)
+	copyEntrySet(y, x)
+	msg := x.digest("x")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if entries := y.newerThan(decodeTestDigest(b, msg)); len(entries) != 0 {
+			b.Fatalf("%d newer entries", len(entries))
+		}
+	}
+}
+
+func BenchmarkMergeDigest10k(b *testing.B)  { benchmarkMergeDigest(b, 10000) }
+func BenchmarkMergeDigest100k(b *testing.B) { benchmarkMergeDigest(b, 100000) }
@@ -3,211 +3,453 @@ package cron
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/go-redis/redis/v8"
-	"github.com/hashicorp/memberlist"
 )
 
-var _ memberlist.Delegate = (*Entries)(nil)
-
-type Entries struct {
-	cli *redis.Client
+var (
+	ErrEntryNotFound = errors.New("entry not found")
+	ErrEntryCorrupt  = errors.New("entry corrupt")
+	ErrActionInvalid = errors.New("invalid action")
+)
 
-	mu    sync.RWMutex
-	local map[string]*Entry
-	list  *memberlist.Memberlist
-	q     *memberlist.TransmitLimitedQueue
+// restoreBatchSize is the number of entries loaded at a time by the backups
+// supporting it
+const restoreBatchSize = 500
+
+// EntryBackup persists entries, so they can be restored after restart.
+// Entries are identified by Entry.Key().
+type EntryBackup interface {
+	Save(ctx context.Context, e *Entry) error
+	Delete(ctx context.Context, key string) error
+	Load(ctx context.Context, key string) (*Entry, error)
+	// Keys lists the keys of all the saved entries
+	Keys(ctx context.Context) ([]string, error)
+}
 
-	keyPrefix string
+// entryBatchLoader is implemented by the backups loading many entries in one
+// round trip
+type entryBatchLoader interface {
+	// LoadBatch loads the entries identified by keys, errs[i] is the error
+	// of keys[i]
+	LoadBatch(ctx context.Context, keys []string) (entries []*Entry, errs []error)
 }
 
-func NewGossipEntries(
-	cli *redis.Client,
-	config *memberlist.Config,
-) *Entries {
-	entries := &Entries{
-		cli:   cli,
-		local: make(map[string]*Entry),
+// decodeEntry decodes an entry saved by a backup
+func decodeEntry(b []byte) (*Entry, error) {
+	e := &Entry{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrEntryCorrupt, err)
 	}
+	return e, nil
+}
 
-	config.Delegate = entries
+// RestoreReport is the result of restoring entries from backup, entries are
+// identified by Entry.Key().
+type RestoreReport struct {
+	Time     int64    `json:"time"` // unix milliseconds
+	Restored []string `json:"restored"`
+	Missing  []string `json:"missing"`
+	Corrupt  []string `json:"corrupt"`
+	Err      string   `json:"error,omitempty"`
+}
 
-	list, err := memberlist.Create(config)
-	if err != nil {
-		Logger.Fatalln(err)
-	}
+// Complete reports whether all the entries are restored
+func (r RestoreReport) Complete() bool {
+	return r.Err == "" && len(r.Missing) == 0 && len(r.Corrupt) == 0
+}
 
-	entries.list = list
-	entries.q = &memberlist.TransmitLimitedQueue{
-		NumNodes: func() int { return list.NumMembers() },
-	}
+// EntryStore keeps the entries of all the nodes in sync, and tracks the
+// members of the cluster. Every change of an entry is stamped with a
+// Version, the newest version of an entry wins on every node.
+type EntryStore interface {
+	// NewVersion stamps a local change
+	NewVersion() Version
+	// Add adds or replaces an entry locally, unless a newer version is known
+	Add(entry *Entry)
+	// Remove marks the entry identified by key deleted at version locally,
+	// unless a newer version is known
+	Remove(key string, version Version)
+	// Get gets the entry identified by key
+	Get(key string) (Entry, bool)
+	// Entries lists all the entries by key, deleted ones included
+	Entries() map[string]Entry
+	// Restore loads the entries identified by keys from backup, missing and
+	// corrupt entries are skipped and reported. The error is returned if the
+	// backup fails, the entries loaded so far are kept. Every load from
+	// backup is bounded by the store timeout, not the whole restore.
+	Restore(ctx context.Context, keys []string) (RestoreReport, error)
+	// Backup applies an action to backup
+	Backup(ctx context.Context, u Action) error
+	// Broadcast sends an action applied locally to the other nodes
+	Broadcast(u Action)
+
+	// Join joins the cluster through the existing nodes
+	Join(existing []string)
+	// UpdateMeta advertises the meta of the local node
+	UpdateMeta(meta NodeMeta)
+	LocalMember() Member
+	Members() []Member
+	// OnMemberEvent adds a hook called on every membership event
+	OnMemberEvent(hook func(MemberEvent))
+	// MemberEvents lists the latest membership events
+	MemberEvents() []MemberEvent
+	Close()
+}
 
-	return entries
+// Member is a node of the cluster
+type Member struct {
+	Name  string    `json:"name"`
+	Addr  string    `json:"addr,omitempty"`
+	State string    `json:"state"`
+	Meta  *NodeMeta `json:"meta,omitempty"`
 }
 
-func (s *Entries) WithKeyPrefix(prefix string) { s.keyPrefix = prefix }
+const (
+	MemberAlive   = "alive"
+	MemberSuspect = "suspect"
+	MemberDead    = "dead"
+	MemberLeft    = "left"
+)
+
+// entrySet is the local copy of entries shared by EntryStore implementations
+type entrySet struct {
+	backup  EntryBackup
+	timeout time.Duration // of every load from backup
+	clock   hlc
 
-func (s *Entries) Join(existing []string) {
-	if _, err := s.list.Join(existing); err != nil {
-		Logger.Fatalln(err)
+	mu           sync.RWMutex
+	local        map[string]*Entry
+	tombstoneTTL time.Duration
+	started      time.Time
+
+	done chan struct{}
+}
+
+func newEntrySet(backup EntryBackup, node string) *entrySet {
+	s := &entrySet{
+		backup:  backup,
+		clock:   hlc{node: node},
+		local:   make(map[string]*Entry),
+		started: time.Now(),
+		done:    make(chan struct{}),
 	}
+
+	go s.collect()
+	return s
 }
 
-func (s *Entries) GetBroadcasts(overhead, limit int) [][]byte {
-	return s.q.GetBroadcasts(overhead, limit)
+// WithTombstoneTTL purges the deleted entries d after their deletion, d
+// must be long enough for all the nodes to learn the deletion, including
+// the ones partitioned away. d <= 0 keeps the tombstones forever.
+func (s *entrySet) WithTombstoneTTL(d time.Duration) {
+	s.mu.Lock()
+	s.tombstoneTTL = d
+	s.mu.Unlock()
 }
 
-func (s *Entries) LocalState(join bool) []byte {
+// WithTimeout bounds every load from backup while restoring, d <= 0 sets no
+// bound.
+func (s *entrySet) WithTimeout(d time.Duration) { s.timeout = d }
+
+func (s *entrySet) ttl() time.Duration {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-
-	b, _ := json.Marshal(s.local)
-	return b
+	return s.tombstoneTTL
 }
 
-func (s *Entries) MergeRemoteState(buf []byte, join bool) {
-	if len(buf) == 0 {
-		return
+// expired reports whether e is a tombstone older than the ttl
+func (s *entrySet) expired(e *Entry, ttl time.Duration, now time.Time) bool {
+	if !e.Deleted || ttl <= 0 {
+		return false
 	}
+	return now.Sub(time.Unix(0, e.Version.Wall*int64(time.Millisecond))) > ttl
+}
 
-	var remotes map[string]*Entry
-	if err := json.Unmarshal(buf, &remotes); err != nil {
-		return
+// purged reports whether e, unknown locally, may be an entry whose tombstone
+// is purged: its version is older than the ttl, while the local node has
+// been up for longer, so it would have learned e when e was changed. This
+// is the purge watermark, stopping a node partitioned away for longer than
+// the ttl from bringing back the entries removed meanwhile. Unversioned
+// entries of older nodes are always accepted.
+func (s *entrySet) purged(e *Entry, now time.Time) bool {
+	ttl := s.tombstoneTTL
+	if e.Deleted || e.Version.IsZero() || ttl <= 0 || now.Sub(s.started) <= ttl {
+		return false
 	}
+	return now.Sub(time.Unix(0, e.Version.Wall*int64(time.Millisecond))) > ttl
+}
 
-	for name, r := range remotes {
-		e, ok := s.Get(name)
-		if !ok {
-			s.Add(r)
-			Logger.Debug("add by push/pull: ", r)
-			continue
+// collect purges the expired tombstones periodically until close
+func (s *entrySet) collect() {
+	for {
+		interval := time.Minute
+		if ttl := s.ttl(); ttl > 0 && ttl/4 < interval {
+			interval = ttl / 4
 		}
 
-		if !e.Deleted && r.Deleted {
-			s.Remove(name)
-			Logger.Debug("delete by push/pull: ", r)
-			continue
+		timer := time.NewTimer(interval)
+		select {
+		case now := <-timer.C:
+			if n := s.purge(now); n > 0 {
+				Logger.Debugf("purge %d tombstones", n)
+			}
+		case <-s.done:
+			timer.Stop()
+			return
 		}
 	}
 }
 
-func (s *Entries) NodeMeta(limit int) []byte {
-	return []byte{}
-}
+func (s *entrySet) purge(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-func (s *Entries) NotifyMsg(b []byte) {
-	if len(b) == 0 {
-		return
+	var n int
+	for key, e := range s.local {
+		if s.expired(e, s.tombstoneTTL, now) {
+			delete(s.local, key)
+			n++
+		}
 	}
+	return n
+}
 
-	var update Action
-	if err := json.Unmarshal(b, &update); err != nil {
-		return
-	}
+func (s *entrySet) close() { close(s.done) }
 
-	switch update.Type {
-	case addType:
-		s.Add(update.Entry)
-		Logger.Debug("add by gossip: ", update.Entry)
+func (s *entrySet) NewVersion() Version { return s.clock.Now() }
 
-	case removeType:
-		s.Remove(update.Entry.Name)
-		Logger.Debug("remove by gossip: ", update.Entry.Name)
+func (s *entrySet) Add(entry *Entry) {
+	s.update(entry, false)
+}
+
+func (s *entrySet) Remove(key string, version Version) {
+	s.mu.RLock()
+	entry, ok := s.local[key]
+	s.mu.RUnlock()
+	if !ok {
+		return
 	}
 
+	s.update(&Entry{
+		Namespace: entry.Namespace,
+		Name:      entry.Name,
+		Spec:      entry.Spec,
+		Deleted:   true,
+		Version:   version,
+	}, false)
 }
 
-func (s *Entries) Add(entry *Entry) {
-	if entry.schedule == nil {
-		entry.schedule, _ = parseSchedule(entry.Spec)
-	}
+// update applies the entry if it is newer than the local one, reports
+// whether it is applied. remote is set for the entries sent by other nodes.
+func (s *entrySet) update(entry *Entry, remote bool) bool {
+	entry.normalize()
+	s.clock.Observe(entry.Version)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.local[entry.Name] = entry
-}
+	now := time.Now()
+	e, ok := s.local[entry.Key()]
+	if ok && !newer(entry, e) {
+		return false
+	}
+	if !ok && remote && s.purged(entry, now) {
+		Logger.Debugf("drop %s of %v, removed and purged", entry.Key(), entry.Version)
+		metrics.IncrCounter([]string{"cron", "entries", "purged_dropped"}, 1)
+		return false
+	}
 
-func (s *Entries) Remove(name string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	// an expired tombstone from a stale peer is not kept, it only deletes
+	// the older entry
+	if s.expired(entry, s.tombstoneTTL, now) {
+		delete(s.local, entry.Key())
+		return ok
+	}
 
-	entry, ok := s.local[name]
-	if !ok {
-		return
+	if entry.schedule == nil {
+		entry.schedule, _ = parseSchedule(entry.Spec)
 	}
+	s.local[entry.Key()] = entry
+	return true
+}
 
-	entry.Deleted = true
+// newer reports whether r is a newer version of e
+func newer(r, e *Entry) bool {
+	if r.Version == e.Version {
+		// unversioned entries of older nodes, deletion wins as before
+		return r.Version.IsZero() && r.Deleted && !e.Deleted
+	}
+	return e.Version.Less(r.Version)
 }
 
-func (s *Entries) Get(name string) (Entry, bool) {
+func (s *entrySet) Get(key string) (Entry, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	e, ok := s.local[name]
+	e, ok := s.local[key]
+	if !ok {
+		return Entry{}, false
+	}
 	return *e, ok
 }
 
-func (s *Entries) Entries() map[string]Entry {
+func (s *entrySet) Entries() map[string]Entry {
 	m := make(map[string]Entry)
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	for k, v := range s.local {
-		m[k] = Entry{
-			Name:     v.Name,
-			Spec:     v.Spec,
-			Deleted:  v.Deleted,
-			schedule: v.schedule,
-		}
+		m[k] = *v
 	}
 	return m
 }
 
-func (s *Entries) Broadcast(u Action) {
-	b, _ := json.Marshal(u)
-
-	s.q.QueueBroadcast(&broadcast{msg: b})
-}
+func (s *entrySet) Restore(ctx context.Context, keys []string) (RestoreReport, error) {
+	report := RestoreReport{Time: time.Now().UnixNano() / int64(time.Millisecond)}
 
-func (s *Entries) Restore(names []string) error {
-	for _, name := range names {
-		e := &Entry{}
+	batchSize := 1
+	if _, ok := s.backup.(entryBatchLoader); ok {
+		batchSize = restoreBatchSize
+	}
 
-		ser, err := s.cli.Get(context.Background(), s.backupKey(name)).Result()
-		if err != nil {
-			return err
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
 		}
-
-		if err = json.Unmarshal([]byte(ser), e); err != nil {
-			return err
+		batch := keys[start:end]
+
+		entries, errs := s.load(ctx, batch)
+		for i, key := range batch {
+			err := errs[i]
+			if err == nil {
+				if _, err = parseSchedule(entries[i].Spec); err != nil {
+					err = fmt.Errorf("%w: %s", ErrEntryCorrupt, err)
+				}
+			}
+
+			switch {
+			case err == nil:
+				report.Restored = append(report.Restored, key)
+				if s.update(entries[i], false) {
+					Logger.Info("restore: ", entries[i])
+				}
+			case errors.Is(err, ErrEntryNotFound):
+				report.Missing = append(report.Missing, key)
+				Logger.Warnf("restore %s: %s", key, err.Error())
+			case errors.Is(err, ErrEntryCorrupt):
+				report.Corrupt = append(report.Corrupt, key)
+				Logger.Errorf("restore %s: %s", key, err.Error())
+			default:
+				report.Err = err.Error()
+				return report, err
+			}
 		}
+	}
+	return report, nil
+}
 
-		s.Add(e)
-		Logger.Info("restore: ", e)
+func (s *entrySet) load(ctx context.Context, keys []string) ([]*Entry, []error) {
+	if b, ok := s.backup.(entryBatchLoader); ok {
+		ctx, cancel := withTimeout(ctx, s.timeout)
+		defer cancel()
+		return b.LoadBatch(ctx, keys)
 	}
-	return nil
+
+	entries, errs := make([]*Entry, len(keys)), make([]error, len(keys))
+	for i, key := range keys {
+		ctx, cancel := withTimeout(ctx, s.timeout)
+		entries[i], errs[i] = s.backup.Load(ctx, key)
+		cancel()
+	}
+	return entries, errs
 }
 
-func (s *Entries) Backup(u Action) error {
+func (s *entrySet) Backup(ctx context.Context, u Action) error {
 	switch u.Type {
 	case addType:
-		ser, err := json.Marshal(u.Entry)
-		if err != nil {
-			return err
-		}
-		return s.cli.Set(context.Background(), s.backupKey(u.Entry.Name), ser, 0).Err()
+		return s.backup.Save(ctx, u.Entry)
 
 	case removeType:
-		return s.cli.Del(context.Background(), s.backupKey(u.Entry.Name)).Err()
+		return s.backup.Delete(ctx, u.Entry.Key())
 	}
 	return nil
 }
 
-func (s *Entries) Close() { s.list.Shutdown() }
+// marshalLegacy is the full state sent to the nodes of protocol 0, which
+// know no namespace: they identify an entry by its name, and would take
+// the entry "team/job" for the job "job" of the default namespace.
+func (s *entrySet) marshalLegacy() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	local := make(map[string]*Entry, len(s.local))
+	for key, e := range s.local {
+		if e.legacy() {
+			local[key] = e
+		}
+	}
+	b, _ := json.Marshal(local)
+	return b
+}
+
+// merge merges the full state of a remote node of protocol 0
+func (s *entrySet) merge(buf []byte, from string) {
+	if len(buf) == 0 {
+		return
+	}
+
+	var remotes map[string]*Entry
+	if err := json.Unmarshal(buf, &remotes); err != nil {
+		return
+	}
+
+	for _, r := range remotes {
+		s.mergeEntry(r, from)
+	}
+}
 
-func (s *Entries) backupKey(key string) string {
-	return s.keyPrefix + "_" + key
+func (s *entrySet) mergeEntry(r *Entry, from string) {
+	if s.update(r, true) {
+		Logger.Debug("update by "+from+": ", r)
+	}
+}
+
+// apply applies an action broadcast by a remote node
+func (s *entrySet) apply(b []byte, from string) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	var update Action
+	if err := json.Unmarshal(b, &update); err != nil {
+		return err
+	}
+	if update.Entry == nil {
+		return ErrActionInvalid
+	}
+
+	switch update.Type {
+	case addType:
+		if s.update(update.Entry, true) {
+			Logger.Debug("add by "+from+": ", update.Entry)
+		}
+
+	case removeType:
+		// keep the tombstone even if the entry is unknown yet, so a stale
+		// add can not resurrect it
+		update.Entry.Deleted = true
+		if s.update(update.Entry, true) {
+			Logger.Debug("remove by "+from+": ", update.Entry.Key())
+		}
+	}
+	return nil
 }
 
 type Type int
@@ -223,8 +465,93 @@ type Action struct {
 	Entry *Entry `json:"entry"`
 }
 
-type broadcast struct{ msg []byte }
+type redisEntryBackup struct {
+	cli       redis.UniversalClient
+	keyPrefix string
+}
+
+func NewRedisEntryBackup(cli redis.UniversalClient, keyPrefix string) EntryBackup {
+	return &redisEntryBackup{
+		cli:       cli,
+		keyPrefix: keyPrefix,
+	}
+}
 
-func (b *broadcast) Invalidates(other memberlist.Broadcast) bool { return false }
-func (b *broadcast) Message() []byte                             { return b.msg }
-func (b *broadcast) Finished()                                   {}
+func (r *redisEntryBackup) Save(ctx context.Context, e *Entry) error {
+	ser, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return r.cli.Set(ctx, r.backupKey(e.Key()), ser, 0).Err()
+}
+
+func (r *redisEntryBackup) Delete(ctx context.Context, key string) error {
+	return r.cli.Del(ctx, r.backupKey(key)).Err()
+}
+
+func (r *redisEntryBackup) Load(ctx context.Context, key string) (*Entry, error) {
+	ser, err := r.cli.Get(ctx, r.backupKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry(ser)
+}
+
+// LoadBatch loads the entries in one pipeline, keys may be in different
+// slots of a cluster
+func (r *redisEntryBackup) LoadBatch(ctx context.Context, keys []string) ([]*Entry, []error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+	_, _ = r.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, r.backupKey(key))
+		}
+		return nil
+	})
+
+	entries, errs := make([]*Entry, len(keys)), make([]error, len(keys))
+	for i, cmd := range cmds {
+		ser, err := cmd.Bytes()
+		switch {
+		case err == redis.Nil:
+			errs[i] = ErrEntryNotFound
+		case err != nil:
+			errs[i] = err
+		default:
+			entries[i], errs[i] = decodeEntry(ser)
+		}
+	}
+	return entries, errs
+}
+
+func (r *redisEntryBackup) Keys(ctx context.Context) ([]string, error) {
+	var (
+		keys  []string
+		match = r.backupKey("*")
+	)
+
+	scan := func(ctx context.Context, cli redis.UniversalClient) error {
+		iter := cli.Scan(ctx, 0, match, 1000).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, strings.TrimPrefix(iter.Val(), r.backupKey("")))
+		}
+		return iter.Err()
+	}
+
+	// keys are spread over all the masters of a cluster
+	if cluster, ok := r.cli.(*redis.ClusterClient); ok {
+		var mu sync.Mutex
+		return keys, cluster.ForEachMaster(ctx, func(ctx context.Context, cli *redis.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return scan(ctx, cli)
+		})
+	}
+	return keys, scan(ctx, r.cli)
+}
+
+func (r *redisEntryBackup) backupKey(key string) string {
+	return r.keyPrefix + "_" + key
+}
@@ -0,0 +1,285 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSpec = "* * * * * *"
+
+func newTestEntrySet(t *testing.T, node string) *entrySet {
+	s := newEntrySet(NewMemoryEntryBackup(), node)
+	t.Cleanup(s.close)
+	return s
+}
+
+func marshalAction(typ Type, e *Entry) []byte {
+	b, _ := json.Marshal(Action{Type: typ, Entry: e})
+	return b
+}
+
+// assertConverged fails unless a and b hold the same entries, the spec of
+// tombstones is left out
+func assertConverged(t *testing.T, a, b *entrySet) {
+	t.Helper()
+
+	ea, eb := a.Entries(), b.Entries()
+	if len(ea) != len(eb) {
+		t.Fatalf("%d entries, %d entries", len(ea), len(eb))
+	}
+	for key, e := range ea {
+		r, ok := eb[key]
+		if !ok || r.Version != e.Version || r.Deleted != e.Deleted || (!e.Deleted && r.Spec != e.Spec) {
+			t.Fatalf("%s diverged: %+v, %+v", key, e, r)
+		}
+	}
+}
+
+func TestEntrySetConcurrentAddRemove(t *testing.T) {
+	a, b := newTestEntrySet(t, "a"), newTestEntrySet(t, "b")
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		e := &Entry{Name: fmt.Sprintf("job%d", i), Spec: testSpec, Version: a.NewVersion()}
+		a.Add(e)
+		if err := b.apply(marshalAction(addType, e), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a adds every entry again while b removes them, each applies the
+	// actions of the other as they come
+	var (
+		wg         sync.WaitGroup
+		fromA      = make(chan []byte, n)
+		fromB      = make(chan []byte, n)
+		errA, errB error
+	)
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			e := &Entry{Name: fmt.Sprintf("job%d", i), Spec: "*/2 * * * * *", Version: a.NewVersion()}
+			a.Add(e)
+			fromA <- marshalAction(addType, e)
+		}
+		close(fromA)
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("job%d", i)
+			version := b.NewVersion()
+			b.Remove(key, version)
+			fromB <- marshalAction(removeType, &Entry{Name: key, Version: version})
+		}
+		close(fromB)
+	}()
+	go func() {
+		defer wg.Done()
+		for msg := range fromB {
+			if err := a.apply(msg, "b"); err != nil {
+				errA = err
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for msg := range fromA {
+			if err := b.apply(msg, "a"); err != nil {
+				errB = err
+			}
+		}
+	}()
+	wg.Wait()
+	if errA != nil || errB != nil {
+		t.Fatal(errA, errB)
+	}
+	assertConverged(t, a, b)
+
+	// a full state exchange changes nothing once converged
+	a.merge(b.marshalLegacy(), "b")
+	b.merge(a.marshalLegacy(), "a")
+	assertConverged(t, a, b)
+}
+
+func TestEntrySetMergeConverges(t *testing.T) {
+	a, b := newTestEntrySet(t, "a"), newTestEntrySet(t, "b")
+
+	e := &Entry{Name: "job", Spec: testSpec, Version: a.NewVersion()}
+	a.Add(e)
+	b.Add(&Entry{Name: "job", Spec: testSpec, Version: e.Version})
+
+	// concurrent changes, missed by the broadcasts
+	a.Add(&Entry{Name: "job", Spec: "*/2 * * * * *", Version: a.NewVersion()})
+	b.Remove("job", b.NewVersion())
+
+	a.merge(b.marshalLegacy(), "b")
+	b.merge(a.marshalLegacy(), "a")
+	assertConverged(t, a, b)
+}
+
+func TestEntrySetTieBrokenByNode(t *testing.T) {
+	var (
+		add    = &Entry{Name: "job", Spec: testSpec, Version: Version{Wall: 1000, Node: "b"}}
+		remove = &Entry{Name: "job", Version: Version{Wall: 1000, Node: "a"}}
+	)
+	if !remove.Version.Less(add.Version) {
+		t.Fatal("the version of node a is not older than the one of node b")
+	}
+
+	// both orders keep the add, stamped by the greater node
+	for _, order := range [][2][]byte{
+		{marshalAction(addType, add), marshalAction(removeType, remove)},
+		{marshalAction(removeType, remove), marshalAction(addType, add)},
+	} {
+		s := newTestEntrySet(t, "c")
+		for _, msg := range order {
+			if err := s.apply(msg, "remote"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		e, ok := s.Get("job")
+		if !ok || e.Deleted || e.Version != add.Version {
+			t.Fatalf("entry %+v, want the add of node b", e)
+		}
+	}
+}
+
+func TestEntrySetUnversionedTombstoneWins(t *testing.T) {
+	var (
+		add    = marshalAction(addType, &Entry{Name: "job", Spec: testSpec})
+		remove = marshalAction(removeType, &Entry{Name: "job", Spec: testSpec})
+	)
+
+	for _, order := range [][2][]byte{{add, remove}, {remove, add}} {
+		s := newTestEntrySet(t, "a")
+		for _, msg := range order {
+			if err := s.apply(msg, "legacy"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		e, ok := s.Get("job")
+		if !ok || !e.Deleted {
+			t.Fatalf("entry %+v, want the tombstone", e)
+		}
+	}
+}
+
+func TestEntrySetRestoreKeepsNewer(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		backup = NewMemoryEntryBackup()
+		s      = newEntrySet(backup, "a")
+	)
+	defer s.close()
+
+	old := &Entry{Name: "job", Spec: testSpec, Version: s.NewVersion()}
+	if err := backup.Save(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	s.Add(&Entry{Name: "job", Spec: "*/2 * * * * *", Version: s.NewVersion()})
+
+	report, err := s.Restore(ctx, []string{"job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Restored) != 1 {
+		t.Fatalf("report %+v, want job restored", report)
+	}
+
+	e, _ := s.Get("job")
+	if e.Spec != "*/2 * * * * *" {
+		t.Fatalf("spec %q, want the newer one in memory", e.Spec)
+	}
+}
+
+// millis is t in unix milliseconds, the wall time of versions
+func millis(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+
+func TestEntrySetPurge(t *testing.T) {
+	s := newTestEntrySet(t, "a")
+	s.WithTombstoneTTL(time.Hour)
+
+	now := time.Now()
+	s.Add(&Entry{Name: "live", Spec: testSpec, Version: Version{Wall: millis(now.Add(-2 * time.Hour)), Node: "a"}})
+	s.Add(&Entry{Name: "removed", Spec: testSpec, Version: Version{Wall: millis(now.Add(-2 * time.Hour)), Node: "a"}})
+	s.Remove("removed", Version{Wall: millis(now.Add(-30 * time.Minute)), Node: "a"})
+	s.Add(&Entry{Name: "recent", Spec: testSpec, Version: Version{Wall: millis(now.Add(-2 * time.Hour)), Node: "a"}})
+	s.Remove("recent", Version{Wall: millis(now.Add(-time.Minute)), Node: "a"})
+
+	if n := s.purge(now.Add(45 * time.Minute)); n != 1 {
+		t.Fatalf("%d tombstones purged, want 1", n)
+	}
+	if _, ok := s.Get("removed"); ok {
+		t.Fatal("expired tombstone kept")
+	}
+	if e, ok := s.Get("recent"); !ok || !e.Deleted {
+		t.Fatal("tombstone purged before the ttl")
+	}
+	if e, ok := s.Get("live"); !ok || e.Deleted {
+		t.Fatal("live entry purged")
+	}
+
+	// an expired tombstone from a stale peer is not kept
+	stale := &Entry{Name: "live", Version: Version{Wall: millis(now.Add(-90 * time.Minute)), Node: "b"}}
+	if err := s.apply(marshalAction(removeType, stale), "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("live"); ok {
+		t.Fatal("entry older than an expired tombstone kept")
+	}
+}
+
+func TestEntrySetPartitionedAdd(t *testing.T) {
+	var (
+		now = time.Now()
+		old = &Entry{Name: "job", Spec: testSpec, Version: Version{Wall: millis(now.Add(-3 * time.Hour)), Node: "b"}}
+	)
+
+	// the node was up when the entry was removed then purged, the add held
+	// by a node partitioned away since is not applied again
+	s := newTestEntrySet(t, "a")
+	s.WithTombstoneTTL(time.Hour)
+	s.started = now.Add(-4 * time.Hour)
+
+	if err := s.apply(marshalAction(addType, old), "b"); err != nil {
+		t.Fatal(err)
+	}
+	state, _ := json.Marshal(map[string]*Entry{"job": old})
+	s.merge(state, "b")
+	if _, ok := s.Get("job"); ok {
+		t.Fatal("purged entry added back by a partitioned node")
+	}
+
+	// changes made since are applied
+	fresh := &Entry{Name: "job", Spec: testSpec, Version: Version{Wall: millis(now), Node: "b"}}
+	if err := s.apply(marshalAction(addType, fresh), "b"); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := s.Get("job"); !ok || e.Version != fresh.Version {
+		t.Fatalf("entry %+v, want the fresh add", e)
+	}
+
+	// a node started since the ttl learns the old entries from the others,
+	// and restores its own from backup
+	joined := newTestEntrySet(t, "c")
+	joined.WithTombstoneTTL(time.Hour)
+	if err := joined.apply(marshalAction(addType, old), "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := joined.Get("job"); !ok {
+		t.Fatal("old entry refused by a new node")
+	}
+
+	s.Add(&Entry{Name: "local", Spec: testSpec, Version: Version{Wall: millis(now.Add(-3 * time.Hour)), Node: "a"}})
+	if _, ok := s.Get("local"); !ok {
+		t.Fatal("old local entry refused")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -36,22 +37,37 @@ type Job interface {
 	Run(context.Context) (result interface{}, err error)
 }
 
+// ExecutionStore records running executions and the execution history of
+// every job.
+type ExecutionStore interface {
+	// Begin records a started execution
+	Begin(ctx context.Context, e *Execution) error
+	// Finish records a finished execution
+	Finish(ctx context.Context, e *Execution) error
+	// Running fetches the executions not finished
+	Running(ctx context.Context) ([]Execution, error)
+	// History fetches the latest executions of a job
+	History(ctx context.Context, jobName string, offset, size int64) ([]Execution, error)
+}
+
 type Executor struct {
-	cli *redis.Client
-	mu  sync.RWMutex
-	wg  sync.WaitGroup
+	store ExecutionStore
+	mu    sync.RWMutex
+	wg    sync.WaitGroup
 
 	node     string
 	receiver chan string
 	jobs     map[string]Job
 
 	maxHistoryNum int64
-	keyPrefix     string
+	timeout       time.Duration
+
+	running int64 // number of running executions, accessed atomically
 }
 
-func NewExecutor(cli *redis.Client, node string) *Executor {
+func NewExecutor(store ExecutionStore, node string) *Executor {
 	e := &Executor{
-		cli: cli,
+		store: store,
 
 		node:     node,
 		receiver: make(chan string),
@@ -70,11 +86,14 @@ func (f *Executor) newExecution(name string) *Execution {
 	}
 }
 
-func (f *Executor) WithKeyPrefix(key string)  { f.keyPrefix = key }
-func (f *Executor) WithMaxHistoryNum(n int64) { f.maxHistoryNum = n }
+func (f *Executor) WithMaxHistoryNum(n int64)   { f.maxHistoryNum = n }
+func (f *Executor) WithTimeout(d time.Duration) { f.timeout = d }
 
 func (f *Executor) Receiver() chan string { return f.receiver }
 
+// Load is the number of executions running on this node
+func (f *Executor) Load() int64 { return atomic.LoadInt64(&f.running) }
+
 func (f *Executor) Contain(jobName string) bool {
 	_, ok := f.Get(jobName)
 	return ok
@@ -105,21 +124,18 @@ func (f *Executor) Jobs() []string {
 	return jobs
 }
 
-func (f *Executor) Running() ([]Execution, error) {
-	ids, err := f.cli.SMembers(context.Background(), f.runningKey()).Result()
-	if err != nil {
-		return nil, err
-	}
-	return f.fetchExecutions(ids), nil
+func (f *Executor) Running(ctx context.Context) ([]Execution, error) {
+	ctx, cancel := withTimeout(ctx, f.timeout)
+	defer cancel()
+
+	return f.store.Running(ctx)
 }
 
-func (f *Executor) History(jobName string, offset, size int64) ([]Execution, error) {
-	ids, err := f.cli.LRange(context.Background(),
-		f.historyKey(jobName), offset, offset+size-1).Result()
-	if err != nil {
-		return nil, err
-	}
-	return f.fetchExecutions(ids), nil
+func (f *Executor) History(ctx context.Context, jobName string, offset, size int64) ([]Execution, error) {
+	ctx, cancel := withTimeout(ctx, f.timeout)
+	defer cancel()
+
+	return f.store.History(ctx, jobName, offset, size)
 }
 
 func (f *Executor) close() { f.wg.Wait() }
@@ -152,13 +168,107 @@ func (f *Executor) executeTask(context context.Context, jobName string) {
 	f.mu.RUnlock()
 
 	if !ok {
-		err = fmt.Errorf("task %s not exist", job)
+		err = fmt.Errorf("task %s not exist", jobName)
 		return
 	}
 	result, err = job.Run(context)
 }
 
-func (f *Executor) fetchExecutions(ids []string) []Execution {
+// abandon finishes the executions left running by a departed node, returns
+// the number of finished executions.
+func (f *Executor) abandon(ctx context.Context, node string, reason error) (int, error) {
+	ctx, cancel := withTimeout(ctx, f.timeout)
+	defer cancel()
+
+	executions, err := f.store.Running(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, e := range executions {
+		if e.Node != node {
+			continue
+		}
+
+		e.finishWith(nil, reason)
+		if err := f.store.Finish(ctx, &e); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// reject records a failed execution of a job which is not run
+func (f *Executor) reject(jobName string, err error) {
+	execution := f.newExecution(jobName)
+	f.beginExecution(execution)
+	execution.finishWith(nil, err)
+	f.finishExecution(execution)
+}
+
+// executions are recorded even during shutdown, so their contexts are not
+// derived from the job context.
+
+func (f *Executor) beginExecution(e *Execution) {
+	f.wg.Add(1)
+	atomic.AddInt64(&f.running, 1)
+
+	ctx, cancel := withTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	if err := f.store.Begin(ctx, e); err != nil {
+		Logger.Errorf("[%s] begin failed: %s", e.ID, err.Error())
+	}
+	Logger.Debugf("[%s] begin", e.ID)
+}
+
+func (f *Executor) finishExecution(e *Execution) {
+	ctx, cancel := withTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	if err := f.store.Finish(ctx, e); err != nil {
+		Logger.Errorf("[%s] finish failed: %s", e.ID, err.Error())
+	}
+	atomic.AddInt64(&f.running, -1)
+	f.wg.Done()
+	Logger.Debugf("[%s] finish", e.ID)
+}
+
+type redisExecutionStore struct {
+	cli redis.UniversalClient
+
+	maxHistoryNum int64
+	keyPrefix     string
+}
+
+func NewRedisExecutionStore(cli redis.UniversalClient, keyPrefix string, maxHistoryNum int64) ExecutionStore {
+	return &redisExecutionStore{
+		cli:           cli,
+		maxHistoryNum: maxHistoryNum,
+		keyPrefix:     keyPrefix,
+	}
+}
+
+func (r *redisExecutionStore) Running(ctx context.Context) ([]Execution, error) {
+	ids, err := r.cli.SMembers(ctx, r.runningKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.fetchExecutions(ctx, ids), nil
+}
+
+func (r *redisExecutionStore) History(ctx context.Context, jobName string, offset, size int64) ([]Execution, error) {
+	ids, err := r.cli.LRange(ctx,
+		r.historyKey(jobName), offset, offset+size-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.fetchExecutions(ctx, ids), nil
+}
+
+func (r *redisExecutionStore) fetchExecutions(ctx context.Context, ids []string) []Execution {
 	var executions = make([]Execution, 0, len(ids))
 	if len(ids) == 0 {
 		return executions
@@ -166,16 +276,16 @@ func (f *Executor) fetchExecutions(ids []string) []Execution {
 
 	var keys = make([]string, len(ids))
 	for i, id := range ids {
-		keys[i] = f.executionKey(id)
+		keys[i] = r.executionKey(id)
 	}
 
-	res, err := f.cli.MGet(context.Background(), keys...).Result()
+	res, err := r.cli.MGet(ctx, keys...).Result()
 	if err != nil {
 		Logger.Errorf("fetchExecutions failed: %s", err.Error())
 	}
 
-	for i, r := range res {
-		ser, ok := r.(string)
+	for i, v := range res {
+		ser, ok := v.(string)
 		if !ok {
 			Logger.Warn("fetchExecutions err", keys[i])
 			continue
@@ -206,22 +316,20 @@ redis.call("LTRIM", KEYS[3], 0, ARGV[3] )
 redis.call("LPUSH", KEYS[3], ARGV[2])
 `)
 
-func (f *Executor) beginExecution(e *Execution) {
-	f.wg.Add(1)
+func (r *redisExecutionStore) Begin(ctx context.Context, e *Execution) error {
 	ser, _ := json.Marshal(e)
 	id := e.ID.String()
 	keys := []string{
-		f.executionKey(id),
-		f.runningKey(),
-		f.historyKey(e.Name),
+		r.executionKey(id),
+		r.runningKey(),
+		r.historyKey(e.Name),
 	}
 	argv := []interface{}{
 		ser,
 		id,
-		f.maxHistoryNum - 2,
+		r.maxHistoryNum - 2,
 	}
-	beginCmd.Run(context.Background(), f.cli, keys, argv...)
-	Logger.Debugf("[%s] begin", e.ID)
+	return ignoreNil(beginCmd.Run(ctx, r.cli, keys, argv...).Err())
 }
 
 // Input:
@@ -235,30 +343,36 @@ redis.call("SETEX", KEYS[1], 86400, ARGV[1])
 redis.call("SREM", KEYS[2], ARGV[2])
 `)
 
-func (f *Executor) finishExecution(e *Execution) {
+func (r *redisExecutionStore) Finish(ctx context.Context, e *Execution) error {
 	ser, _ := json.Marshal(e)
 	id := e.ID.String()
 	keys := []string{
-		f.executionKey(id),
-		f.runningKey(),
+		r.executionKey(id),
+		r.runningKey(),
 	}
 	argv := []interface{}{
 		ser,
 		id,
 	}
-	finishCmd.Run(context.Background(), f.cli, keys, argv...)
-	f.wg.Done()
-	Logger.Debugf("[%s] finish", e.ID)
+	return ignoreNil(finishCmd.Run(ctx, r.cli, keys, argv...).Err())
+}
+
+func (r *redisExecutionStore) historyKey(name string) string {
+	return r.keyPrefix + "_hist_" + name
 }
 
-func (f *Executor) historyKey(name string) string {
-	return f.keyPrefix + "_hist_" + name
+func (r *redisExecutionStore) runningKey() string {
+	return r.keyPrefix + "_running"
 }
 
-func (f *Executor) runningKey() string {
-	return f.keyPrefix + "_running"
+func (r *redisExecutionStore) executionKey(id string) string {
+	return r.keyPrefix + "_" + id
 }
 
-func (f *Executor) executionKey(id string) string {
-	return f.keyPrefix + "_" + id
+// ignoreNil ignores the redis.Nil returned by scripts without return value
+func ignoreNil(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
 }
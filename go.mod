@@ -3,10 +3,14 @@ module github.com/yinyajun/cron
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/armon/go-metrics v0.0.0-20180917152333-f0300d1749da
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/google/uuid v1.3.0
 	github.com/hashicorp/memberlist v0.5.0
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.9.0
 	github.com/yinyajun/cron-admin v0.0.0-20230330130949-ede51877cbb0
+	go.etcd.io/bbolt v1.3.6
 )
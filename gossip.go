@@ -0,0 +1,266 @@
+package cron
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+var (
+	_ EntryStore               = (*GossipEntries)(nil)
+	_ memberlist.Delegate      = (*GossipEntries)(nil)
+	_ memberlist.EventDelegate = (*GossipEntries)(nil)
+)
+
+// GossipEntries syncs entries by gossip, actions are broadcast and the full
+// state is exchanged by push/pull.
+type GossipEntries struct {
+	*entrySet
+	*memberLog
+
+	list      *memberlist.Memberlist
+	q         *memberlist.TransmitLimitedQueue
+	keyring   *memberlist.Keyring // nil if not encrypted
+	plaintext bool                // unencrypted messages are accepted or sent
+
+	metaMu sync.RWMutex
+	meta   NodeMeta
+}
+
+func NewGossipEntries(
+	backup EntryBackup,
+	config *memberlist.Config,
+) *GossipEntries {
+	entries := &GossipEntries{
+		entrySet:  newEntrySet(backup, config.Name),
+		memberLog: newMemberLog(),
+	}
+
+	config.Delegate = entries
+	config.Events = entries
+
+	list, err := memberlist.Create(config)
+	if err != nil {
+		Logger.Fatalln(err)
+	}
+
+	entries.list = list
+	entries.keyring = config.Keyring
+	entries.plaintext = !config.GossipVerifyIncoming || !config.GossipVerifyOutgoing
+	entries.q = &memberlist.TransmitLimitedQueue{
+		NumNodes: func() int { return list.NumMembers() },
+	}
+
+	return entries
+}
+
+func (s *GossipEntries) Join(existing []string) {
+	if _, err := s.list.Join(existing); err != nil {
+		Logger.Fatalln(err)
+	}
+}
+
+func (s *GossipEntries) GetBroadcasts(overhead, limit int) [][]byte {
+	return s.q.GetBroadcasts(overhead, limit)
+}
+
+// LocalState is the digest of the local entries, see digest, or the entries
+// of the default namespace while nodes of protocol 0 are alive. The state of
+// a join is sent before the joining node is known, a node of protocol 0 gets
+// the entries by the next push/pull.
+func (s *GossipEntries) LocalState(join bool) []byte {
+	if s.protocol() == 0 {
+		return s.marshalLegacy()
+	}
+	return s.digest(s.list.LocalNode().Name)
+}
+
+// MergeRemoteState sends the remote node the entries it lacks, the remote
+// node does the same. The full state of older nodes is merged as is.
+func (s *GossipEntries) MergeRemoteState(buf []byte, join bool) {
+	if !isFramed(buf) {
+		s.merge(buf, "push/pull")
+		return
+	}
+
+	kind, r, err := unframe(buf)
+	if err == nil && kind != kindDigest {
+		err = ErrStateInvalid
+	}
+	if err != nil {
+		drop("push/pull", err)
+		return
+	}
+
+	d, err := decodeDigest(r)
+	if err != nil {
+		drop("push/pull", err)
+		return
+	}
+
+	entries := s.newerThan(d)
+	if len(entries) == 0 {
+		return
+	}
+
+	var node *memberlist.Node
+	for _, m := range s.list.Members() {
+		if m.Name == d.Node {
+			node = m
+			break
+		}
+	}
+	if node == nil {
+		return
+	}
+
+	// push/pull must not block
+	go func() {
+		for _, msg := range encodeEntries(entries) {
+			if err := s.list.SendReliable(node, msg); err != nil {
+				Logger.Warnf("send entries to %s failed: %s", node.Name, err.Error())
+				return
+			}
+		}
+	}()
+}
+
+func (s *GossipEntries) NodeMeta(limit int) []byte {
+	s.metaMu.RLock()
+	meta := s.meta
+	s.metaMu.RUnlock()
+
+	meta.Protocol = ProtocolVersion
+	return meta.encode(limit)
+}
+
+func (s *GossipEntries) UpdateMeta(meta NodeMeta) {
+	s.metaMu.Lock()
+	s.meta = meta
+	s.metaMu.Unlock()
+
+	if err := s.list.UpdateNode(time.Second); err != nil {
+		Logger.Warn("update node meta failed: ", err.Error())
+	}
+}
+
+func (s *GossipEntries) NotifyMsg(b []byte) {
+	if !isFramed(b) {
+		if err := s.apply(b, "gossip"); err != nil {
+			drop("gossip", err)
+		}
+		return
+	}
+
+	kind, r, err := unframe(b)
+	if err != nil {
+		drop("gossip", err)
+		return
+	}
+
+	switch kind {
+	case kindAction:
+		body, _ := ioutil.ReadAll(r)
+		err = s.apply(body, "gossip")
+	case kindKey:
+		err = s.notifyKey(r)
+	case kindEntries:
+		err = s.mergeEntries(r, "push/pull")
+	default:
+		err = fmt.Errorf("%w: kind %d", ErrProtocolUnsupported, kind)
+	}
+	if err != nil {
+		drop("gossip", err)
+	}
+}
+
+// Broadcast sends the action in the protocol every alive node speaks. The
+// actions on other namespaces are always framed, the nodes of protocol 0
+// drop them.
+func (s *GossipEntries) Broadcast(u Action) {
+	b, _ := json.Marshal(u)
+	if !u.Entry.legacy() || s.protocol() > 0 {
+		b = frame(kindAction, b)
+	}
+
+	s.q.QueueBroadcast(&broadcast{msg: b, key: u.Entry.Key(), version: u.Entry.Version})
+}
+
+// Queued is the number of broadcasts waiting to be gossiped
+func (s *GossipEntries) Queued() int {
+	return s.q.NumQueued()
+}
+
+func (s *GossipEntries) NotifyJoin(node *memberlist.Node) {
+	s.record(MemberJoin, gossipMember(node))
+}
+
+func (s *GossipEntries) NotifyLeave(node *memberlist.Node) {
+	if node.State == memberlist.StateLeft {
+		s.record(MemberLeave, gossipMember(node))
+		return
+	}
+	s.record(MemberFail, gossipMember(node))
+}
+
+// NotifyUpdate is called on meta changes, which are not membership changes
+func (s *GossipEntries) NotifyUpdate(node *memberlist.Node) {}
+
+func (s *GossipEntries) LocalMember() Member {
+	return gossipMember(s.list.LocalNode())
+}
+
+func (s *GossipEntries) Members() []Member {
+	nodes := s.list.Members()
+
+	members := make([]Member, len(nodes))
+	for i, node := range nodes {
+		members[i] = gossipMember(node)
+	}
+	return members
+}
+
+func (s *GossipEntries) Close() {
+	s.entrySet.close()
+	s.list.Shutdown()
+}
+
+func gossipMember(node *memberlist.Node) Member {
+	m := Member{Name: node.Name, Addr: node.Address(), Meta: decodeNodeMeta(node.Meta)}
+
+	switch node.State {
+	case memberlist.StateAlive:
+		m.State = MemberAlive
+	case memberlist.StateSuspect:
+		m.State = MemberSuspect
+	case memberlist.StateDead:
+		m.State = MemberDead
+	case memberlist.StateLeft:
+		m.State = MemberLeft
+	}
+	return m
+}
+
+// broadcast is a message queued for gossip. Actions carry the key and the
+// version of their entry, so the queued actions of an entry are dropped once
+// a newer one is queued, the receivers would discard them anyway.
+type broadcast struct {
+	msg     []byte
+	key     string // empty if not an action
+	version Version
+}
+
+func (b *broadcast) Invalidates(other memberlist.Broadcast) bool {
+	o, ok := other.(*broadcast)
+	if !ok || b.key == "" || o.key != b.key {
+		return false
+	}
+	return !b.version.Less(o.version)
+}
+
+func (b *broadcast) Message() []byte { return b.msg }
+func (b *broadcast) Finished()       {}
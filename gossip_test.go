@@ -0,0 +1,78 @@
+package cron
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+)
+
+func newTestGossipEntries(t *testing.T, keyring *memberlist.Keyring) *GossipEntries {
+	return newNamedTestGossipEntries(t, t.Name(), keyring)
+}
+
+func newNamedTestGossipEntries(t *testing.T, name string, keyring *memberlist.Keyring) *GossipEntries {
+	config := memberlist.DefaultLocalConfig()
+	config.Name = name
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = 0
+	config.Keyring = keyring
+	config.Logger = log.New(ioutil.Discard, "", 0)
+
+	s := NewGossipEntries(NewMemoryEntryBackup(), config)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestBroadcastInvalidated(t *testing.T) {
+	s := newTestGossipEntries(t, nil)
+
+	const keys = 10
+	for i := 0; i < 1000; i++ {
+		for k := 0; k < keys; k++ {
+			typ := addType
+			if i%2 == 1 {
+				typ = removeType
+			}
+			s.Broadcast(Action{
+				Type:  typ,
+				Entry: &Entry{Name: fmt.Sprintf("job%d", k), Spec: testSpec, Version: s.NewVersion()},
+			})
+		}
+	}
+
+	if n := s.Queued(); n != keys {
+		t.Fatalf("%d broadcasts queued, want one per key: %d", n, keys)
+	}
+}
+
+func TestKeyBroadcastNotInvalidated(t *testing.T) {
+	primary := []byte("0123456789abcdef")
+	keyring, err := memberlist.NewKeyring(nil, primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestGossipEntries(t, keyring)
+
+	// an action broadcast in between must not invalidate them either
+	keys := []string{"fedcba9876543210", "abcdef0123456789", "9876543210fedcba"}
+	for i, k := range keys {
+		if err := s.RotateKey(KeyInstall, base64.StdEncoding.EncodeToString([]byte(k))); err != nil {
+			t.Fatal(err)
+		}
+		s.Broadcast(Action{
+			Type:  addType,
+			Entry: &Entry{Name: fmt.Sprintf("job%d", i), Spec: testSpec, Version: s.NewVersion()},
+		})
+	}
+	if err := s.RotateKey(KeyRemove, base64.StdEncoding.EncodeToString([]byte(keys[0]))); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, want := s.Queued(), 2*len(keys)+1; n != want {
+		t.Fatalf("%d broadcasts queued, want %d", n, want)
+	}
+}
@@ -0,0 +1,229 @@
+package cron
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/memberlist"
+)
+
+var (
+	ErrKeyringDisabled  = errors.New("gossip encryption disabled")
+	ErrKeyOpInvalid     = errors.New("invalid key operation")
+	ErrKeyringPlaintext = errors.New("gossip key rotation refused while plaintext is allowed")
+	ErrKeyNotInstalled  = errors.New("gossip key not installed on every node")
+	ErrKeyRotationOff   = errors.New("gossip key rotation by http disabled, see gossip.key_rotation")
+)
+
+// KeyOp is an operation on the gossip keyring. A new key is rotated in
+// cluster-wide by installing it, using it as the primary key once every node
+// has it, then removing the old key.
+type KeyOp string
+
+const (
+	KeyInstall KeyOp = "install"
+	KeyUse     KeyOp = "use"
+	KeyRemove  KeyOp = "remove"
+)
+
+// Keys lists the gossip keys of a node by fingerprint, the keys themselves
+// are never exposed.
+type Keys struct {
+	Primary string   `json:"primary"`
+	Keys    []string `json:"keys"`
+}
+
+// keyring is implemented by the entry stores encrypting their traffic
+type keyring interface {
+	Keys() (Keys, error)
+	RotateKey(op KeyOp, key string) error
+}
+
+// keyMsg is a keyring operation broadcast to the other nodes, nodes of
+// protocol 0 drop it.
+type keyMsg struct {
+	Op  KeyOp  `json:"key_op"`
+	Key string `json:"key"` // base64
+}
+
+// newKeyring builds the keyring of base64 keys, nil if primary is empty
+func newKeyring(primary string, secondaries []string) (*memberlist.Keyring, error) {
+	if primary == "" {
+		return nil, nil
+	}
+
+	pk, err := decodeKey(primary)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(secondaries))
+	for _, s := range secondaries {
+		k, err := decodeKey(s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return memberlist.NewKeyring(keys, pk)
+}
+
+func decodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gossip key: %w", err)
+	}
+	if err := memberlist.ValidateKey(key); err != nil {
+		return nil, fmt.Errorf("invalid gossip key: %w", err)
+	}
+	return key, nil
+}
+
+// keyFingerprint identifies a key without revealing it
+func keyFingerprint(key []byte) string {
+	if len(key) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *GossipEntries) Keys() (Keys, error) {
+	if s.keyring == nil {
+		return Keys{}, ErrKeyringDisabled
+	}
+
+	keys := Keys{Primary: keyFingerprint(s.keyring.GetPrimaryKey())}
+	for _, k := range s.keyring.GetKeys() {
+		keys.Keys = append(keys.Keys, keyFingerprint(k))
+	}
+	return keys, nil
+}
+
+// RotateKey applies a keyring operation locally, then broadcasts it to the
+// other nodes, which apply it on receipt. A key is used only once every
+// alive node advertises it, the nodes missing it could no longer gossip.
+func (s *GossipEntries) RotateKey(op KeyOp, key string) error {
+	if s.plaintext {
+		return ErrKeyringPlaintext
+	}
+	if op == KeyUse {
+		if err := s.installed(key); err != nil {
+			return err
+		}
+	}
+
+	msg := keyMsg{Op: op, Key: key}
+	if err := s.applyKey(msg); err != nil {
+		return err
+	}
+
+	b, _ := json.Marshal(msg)
+	s.q.QueueBroadcast(&broadcast{msg: frame(kindKey, b)})
+	return nil
+}
+
+// installed checks that every alive node advertises the key in its meta
+func (s *GossipEntries) installed(key string) error {
+	k, err := decodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	var (
+		fingerprint = keyFingerprint(k)
+		local       = s.list.LocalNode().Name
+		missing     []string
+	)
+	for _, node := range s.list.Members() {
+		if node.Name == local || node.State != memberlist.StateAlive {
+			continue
+		}
+		if !decodeNodeMeta(node.Meta).hasKey(fingerprint) {
+			missing = append(missing, node.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing on %v", ErrKeyNotInstalled, missing)
+	}
+	return nil
+}
+
+func (s *GossipEntries) applyKey(msg keyMsg) error {
+	if s.keyring == nil {
+		return ErrKeyringDisabled
+	}
+
+	key, err := decodeKey(msg.Key)
+	if err != nil {
+		return err
+	}
+
+	switch msg.Op {
+	case KeyInstall:
+		err = s.keyring.AddKey(key)
+	case KeyUse:
+		err = s.keyring.UseKey(key)
+	case KeyRemove:
+		err = s.keyring.RemoveKey(key)
+	default:
+		return ErrKeyOpInvalid
+	}
+	if err != nil {
+		return err
+	}
+	Logger.Infof("gossip key %s: %s", msg.Op, keyFingerprint(key))
+	return nil
+}
+
+// notifyKey applies a keyring operation received by gossip
+func (s *GossipEntries) notifyKey(r io.Reader) error {
+	var msg keyMsg
+	if err := json.NewDecoder(r).Decode(&msg); err != nil {
+		return err
+	}
+
+	if err := s.applyKey(msg); err != nil {
+		Logger.Errorf("gossip key %s failed: %s", msg.Op, err.Error())
+	}
+	return nil
+}
+
+// gossipKeys are the fingerprints of the gossip keys, advertised in node meta
+func (a *Agent) gossipKeys() Keys {
+	k, ok := a.entries.(keyring)
+	if !ok {
+		return Keys{}
+	}
+	keys, _ := k.Keys()
+	return keys
+}
+
+// Keys lists the gossip keys of the local node
+func (a *Agent) Keys() (Keys, error) {
+	k, ok := a.entries.(keyring)
+	if !ok {
+		return Keys{}, ErrKeyringDisabled
+	}
+	return k.Keys()
+}
+
+// RotateKey applies a keyring operation on all the nodes, key is base64.
+// Nodes apply it asynchronously, the keys advertised in node meta tell when
+// a step is done.
+func (a *Agent) RotateKey(op KeyOp, key string) error {
+	k, ok := a.entries.(keyring)
+	if !ok {
+		return ErrKeyringDisabled
+	}
+	if err := k.RotateKey(op, key); err != nil {
+		return err
+	}
+
+	a.refreshMeta()
+	return nil
+}
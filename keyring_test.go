@@ -0,0 +1,107 @@
+package cron
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+)
+
+func hasFingerprint(keys Keys, fingerprint string) bool {
+	for _, k := range keys.Keys {
+		if k == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRotateKey(t *testing.T) {
+	var (
+		oldKey = []byte("0123456789abcdef")
+		newKey = []byte("fedcba9876543210")
+		nodes  = make([]*GossipEntries, 2)
+	)
+	for i, name := range []string{"a", "b"} {
+		keyring, err := memberlist.NewKeyring(nil, oldKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = newNamedTestGossipEntries(t, name, keyring)
+	}
+	a, b := nodes[0], nodes[1]
+	b.Join([]string{a.LocalMember().Addr})
+	eventually(t, func() bool { return len(a.Members()) == 2 }, "b not joined")
+
+	var (
+		encoded    = base64.StdEncoding.EncodeToString(newKey)
+		oldPrint   = keyFingerprint(oldKey)
+		newPrint   = keyFingerprint(newKey)
+		keysOf     = func(s *GossipEntries) Keys { keys, _ := s.Keys(); return keys }
+		advertised = func(s *GossipEntries) {
+			s.UpdateMeta(NodeMeta{Protocol: ProtocolVersion, Key: keysOf(s).Primary, Keys: keysOf(s).Keys})
+		}
+	)
+
+	if err := a.RotateKey(KeyInstall, encoded); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return hasFingerprint(keysOf(b), newPrint) }, "key not installed on b")
+
+	// used only once every node advertises it
+	if err := a.RotateKey(KeyUse, encoded); !errors.Is(err, ErrKeyNotInstalled) {
+		t.Fatalf("use before b advertises the key: %v", err)
+	}
+	if keysOf(a).Primary != oldPrint {
+		t.Fatal("primary key changed by a refused use")
+	}
+	advertised(b)
+	eventually(t, func() bool { return a.RotateKey(KeyUse, encoded) == nil }, "key not used once installed everywhere")
+	eventually(t, func() bool { return keysOf(b).Primary == newPrint }, "key not used on b")
+
+	if err := a.RotateKey(KeyRemove, base64.StdEncoding.EncodeToString(oldKey)); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return !hasFingerprint(keysOf(b), oldPrint) }, "old key not removed on b")
+
+	// the nodes still gossip with the new key alone
+	advertised(b)
+	eventually(t, func() bool {
+		for _, m := range a.Members() {
+			if m.Name == "b" && m.State == MemberAlive && m.Meta != nil && m.Meta.Key == newPrint {
+				return true
+			}
+		}
+		return false
+	}, "meta of b not received with the new key")
+}
+
+func TestKeysHandlerRotation(t *testing.T) {
+	a := newTestAgent(t)
+
+	post := func(contentType string) string {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/keys", strings.NewReader(`{"op": "install", "key": "ZmVkY2JhOTg3NjU0MzIxMA=="}`))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		newKeysHandlerFunc(a)(w, r)
+		return w.Body.String()
+	}
+
+	// disabled by default
+	if body := post("application/json"); !strings.Contains(body, ErrKeyRotationOff.Error()) {
+		t.Fatalf("rotation answered %s, want it disabled", body)
+	}
+
+	// a form or plain text post is refused, browsers send it cross-origin
+	a.keyRotation = true
+	if body := post("text/plain"); !strings.Contains(body, `"code":1011`) || strings.Contains(body, ErrKeyringDisabled.Error()) {
+		t.Fatalf("plain text rotation answered %s", body)
+	}
+	if body := post("application/json; charset=utf-8"); !strings.Contains(body, ErrKeyringDisabled.Error()) {
+		t.Fatalf("rotation answered %s, want it tried", body)
+	}
+}
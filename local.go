@@ -0,0 +1,56 @@
+package cron
+
+import "sync"
+
+var _ EntryStore = (*LocalEntries)(nil)
+
+// LocalEntries keeps the entries of a single node, nothing is shared with
+// other nodes and no port is bound.
+type LocalEntries struct {
+	*entrySet
+	*memberLog
+
+	node string
+
+	metaMu sync.RWMutex
+	meta   NodeMeta
+}
+
+func NewLocalEntries(backup EntryBackup, node string) *LocalEntries {
+	return &LocalEntries{
+		entrySet:  newEntrySet(backup, node),
+		memberLog: newMemberLog(),
+		node:      node,
+	}
+}
+
+// Join is ignored, a standalone node joins no cluster
+func (s *LocalEntries) Join(existing []string) {
+	if len(existing) > 0 {
+		Logger.Warnf("standalone node does not join %v", existing)
+	}
+}
+
+func (s *LocalEntries) Broadcast(u Action) {}
+
+func (s *LocalEntries) UpdateMeta(meta NodeMeta) {
+	s.metaMu.Lock()
+	s.meta = meta
+	s.metaMu.Unlock()
+}
+
+func (s *LocalEntries) LocalMember() Member {
+	s.metaMu.RLock()
+	meta := s.meta
+	s.metaMu.RUnlock()
+
+	return Member{Name: s.node, State: MemberAlive, Meta: &meta}
+}
+
+func (s *LocalEntries) Members() []Member {
+	return []Member{s.LocalMember()}
+}
+
+func (s *LocalEntries) Close() {
+	s.entrySet.close()
+}
@@ -0,0 +1,61 @@
+package cron
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalEntries(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		backup = NewMemoryEntryBackup()
+		s      = NewLocalEntries(backup, "a")
+	)
+	defer s.Close()
+
+	// no cluster is joined, the local node is the only member
+	s.Join([]string{"127.0.0.1:7946"})
+	s.UpdateMeta(NodeMeta{Labels: map[string]string{"zone": "a"}})
+	members := s.Members()
+	if len(members) != 1 || members[0].Name != "a" || members[0].State != MemberAlive || members[0].Meta.Labels["zone"] != "a" {
+		t.Fatalf("members %+v, want the local node", members)
+	}
+
+	e := &Entry{Name: "job", Spec: testSpec, Version: s.NewVersion()}
+	if err := s.Backup(ctx, Action{Type: addType, Entry: e}); err != nil {
+		t.Fatal(err)
+	}
+	s.Add(e)
+	s.Broadcast(Action{Type: addType, Entry: e})
+	if got, ok := s.Get("job"); !ok || got.Version != e.Version {
+		t.Fatalf("entry %+v, want the added one", got)
+	}
+
+	// a restarted node restores its entries from backup
+	restarted := NewLocalEntries(backup, "a")
+	defer restarted.Close()
+	report, err := restarted.Restore(ctx, []string{"job"})
+	if err != nil || !report.Complete() {
+		t.Fatalf("restore %+v, %v", report, err)
+	}
+	if _, ok := restarted.Get("job"); !ok {
+		t.Fatal("entry not restored")
+	}
+
+	s.Remove("job", s.NewVersion())
+	if got, ok := s.Get("job"); !ok || !got.Deleted {
+		t.Fatalf("entry %+v, want the tombstone", got)
+	}
+}
+
+func TestStandaloneAgent(t *testing.T) {
+	a := newTestAgent(t)
+	if _, ok := a.entries.(*LocalEntries); !ok {
+		t.Fatalf("entry store %T, want local entries", a.entries)
+	}
+
+	a.refreshMeta()
+	if m := a.entries.LocalMember(); m.Meta == nil || m.Meta.Version != AgentVersion {
+		t.Fatalf("local member %+v, want the meta advertised", m)
+	}
+}
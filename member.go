@@ -0,0 +1,84 @@
+package cron
+
+import (
+	"sync"
+	"time"
+)
+
+// memberEventsSize is the number of the latest membership events kept
+const memberEventsSize = 256
+
+type MemberEventType string
+
+const (
+	MemberJoin  MemberEventType = "join"
+	MemberLeave MemberEventType = "leave" // left gracefully
+	MemberFail  MemberEventType = "fail"  // dead without leaving
+)
+
+// MemberEvent is a change of the cluster membership seen by the local node
+type MemberEvent struct {
+	Type   MemberEventType `json:"type"`
+	Member Member          `json:"member"`
+	Time   int64           `json:"time"` // unix milliseconds
+}
+
+// memberLog keeps the latest membership events in a ring buffer, and calls
+// the hooks on every event.
+type memberLog struct {
+	mu     sync.RWMutex
+	events []MemberEvent
+	next   int // position of the next event in events
+	full   bool
+	hooks  []func(MemberEvent)
+}
+
+func newMemberLog() *memberLog {
+	return &memberLog{events: make([]MemberEvent, memberEventsSize)}
+}
+
+// OnMemberEvent adds a hook called on every membership event, hooks are
+// called in a separate goroutine.
+func (l *memberLog) OnMemberEvent(hook func(MemberEvent)) {
+	l.mu.Lock()
+	l.hooks = append(l.hooks, hook)
+	l.mu.Unlock()
+}
+
+// MemberEvents lists the latest membership events, oldest first
+func (l *memberLog) MemberEvents() []MemberEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if !l.full {
+		return append([]MemberEvent(nil), l.events[:l.next]...)
+	}
+	return append(append([]MemberEvent(nil), l.events[l.next:]...), l.events[:l.next]...)
+}
+
+func (l *memberLog) record(typ MemberEventType, m Member) {
+	e := MemberEvent{
+		Type:   typ,
+		Member: m,
+		Time:   time.Now().UnixNano() / int64(time.Millisecond),
+	}
+
+	l.mu.Lock()
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+	hooks := make([]func(MemberEvent), len(l.hooks))
+	copy(hooks, l.hooks)
+	l.mu.Unlock()
+
+	Logger.Infof("member %s: %s", typ, m.Name)
+
+	// memberlist delegates must not block
+	go func() {
+		for _, hook := range hooks {
+			hook(e)
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMemberLog(t *testing.T) {
+	l := newMemberLog()
+
+	hooked := make(chan MemberEvent, memberEventsSize+10)
+	l.OnMemberEvent(func(e MemberEvent) { hooked <- e })
+
+	l.record(MemberJoin, Member{Name: "a"})
+	l.record(MemberFail, Member{Name: "b"})
+	if events := l.MemberEvents(); len(events) != 2 || events[0].Member.Name != "a" || events[1].Type != MemberFail {
+		t.Fatalf("events %+v", events)
+	}
+
+	// the oldest events are dropped once the ring is full
+	for i := 0; i < memberEventsSize; i++ {
+		l.record(MemberLeave, Member{Name: fmt.Sprintf("node%d", i)})
+	}
+	events := l.MemberEvents()
+	if len(events) != memberEventsSize {
+		t.Fatalf("%d events kept, want %d", len(events), memberEventsSize)
+	}
+	for i, e := range events {
+		if want := fmt.Sprintf("node%d", i); e.Member.Name != want {
+			t.Fatalf("event %d of %s, want %s", i, e.Member.Name, want)
+		}
+	}
+
+	for i := 0; i < memberEventsSize+2; i++ {
+		<-hooked
+	}
+}
+
+func TestExecutorAbandon(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		store = NewMemoryExecutionStore(5)
+		f     = NewExecutor(store, "a")
+	)
+
+	for _, node := range []string{"a", "b", "b", "c"} {
+		if err := store.Begin(ctx, &Execution{ID: uuid.New(), Name: "job", Node: node}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := f.abandon(ctx, "b", ErrNodeDeparted)
+	if err != nil || n != 2 {
+		t.Fatalf("%d executions abandoned, %v, want 2", n, err)
+	}
+
+	running, err := store.Running(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 2 {
+		t.Fatalf("%d executions running, want the ones of a and c", len(running))
+	}
+	for _, e := range running {
+		if e.Node == "b" {
+			t.Fatalf("execution of the departed node still running: %+v", e)
+		}
+	}
+
+	history, err := store.History(ctx, "job", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed int
+	for _, e := range history {
+		if e.Node == "b" && !e.Success && e.Result == "Error: "+ErrNodeDeparted.Error() {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("history %+v, want 2 failed executions of b", history)
+	}
+}
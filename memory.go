@@ -0,0 +1,264 @@
+package cron
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryTimeline is a process-local Timeline, mainly for tests and
+// single-node deployments. It follows the same semantics as redisTimeline:
+// times are kept with second precision and TryModify compares both the
+// time and the displayed state of the event.
+type memoryTimeline struct {
+	mu     sync.RWMutex
+	events map[string]Event
+}
+
+func NewMemoryTimeline() Timeline {
+	return &memoryTimeline{
+		events: make(map[string]Event),
+	}
+}
+
+func (m *memoryTimeline) Add(ctx context.Context, event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.Time = time.Unix(event.Time.Unix(), 0)
+	m.events[event.Name] = event
+	return nil
+}
+
+func (m *memoryTimeline) Remove(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.events, name)
+	return nil
+}
+
+func (m *memoryTimeline) Hide(ctx context.Context, name string) error {
+	return m.setDisplayed(ctx, name, false)
+}
+
+func (m *memoryTimeline) Display(ctx context.Context, name string) error {
+	return m.setDisplayed(ctx, name, true)
+}
+
+func (m *memoryTimeline) setDisplayed(ctx context.Context, name string, displayed bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event, ok := m.events[name]
+	if !ok {
+		return ErrEventNotFound
+	}
+
+	event.Displayed = displayed
+	m.events[name] = event
+	return nil
+}
+
+func (m *memoryTimeline) TryModify(ctx context.Context, event Event, t time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.modify(event, t), nil
+}
+
+func (m *memoryTimeline) TryModifyBatch(ctx context.Context, claims []Claim) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []Event
+	for _, c := range claims {
+		if m.modify(c.Event, c.Next) {
+			events = append(events, c.Event)
+		}
+	}
+	return events, nil
+}
+
+func (m *memoryTimeline) modify(event Event, t time.Time) bool {
+	cur, ok := m.events[event.Name]
+	if !ok || cur.Time.Unix() != event.Time.Unix() || cur.Displayed != event.Displayed {
+		return false
+	}
+
+	cur.Time = time.Unix(t.Unix(), 0)
+	m.events[event.Name] = cur
+	return true
+}
+
+func (m *memoryTimeline) Find(ctx context.Context, name string) (Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.events[name], nil
+}
+
+func (m *memoryTimeline) FindEarliest(ctx context.Context) (Event, error) {
+	events := m.sorted(func(e Event) bool { return e.Displayed })
+	if len(events) == 0 {
+		return Event{}, nil
+	}
+	return events[0], nil
+}
+
+func (m *memoryTimeline) FetchHistory(ctx context.Context, t time.Time, after Event, limit int64) ([]Event, error) {
+	// limited for displayed events
+	events := m.sorted(func(e Event) bool {
+		return e.Displayed && e.Time.Unix() <= t.Unix() && eventAfter(e, after)
+	})
+	if limit > 0 && int64(len(events)) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (m *memoryTimeline) Events(ctx context.Context) ([]Event, error) {
+	return m.sorted(func(Event) bool { return true }), nil
+}
+
+func (m *memoryTimeline) Close() {}
+
+// sorted returns the events matching filter, ordered by sortEvents.
+func (m *memoryTimeline) sorted(filter func(Event) bool) []Event {
+	m.mu.RLock()
+	events := make([]Event, 0, len(m.events))
+	for _, e := range m.events {
+		if filter(e) {
+			events = append(events, e)
+		}
+	}
+	m.mu.RUnlock()
+
+	sortEvents(events)
+	return events
+}
+
+type memoryEntryBackup struct {
+	mu      sync.RWMutex
+	entries map[string]Entry
+}
+
+func NewMemoryEntryBackup() EntryBackup {
+	return &memoryEntryBackup{
+		entries: make(map[string]Entry),
+	}
+}
+
+func (m *memoryEntryBackup) Save(ctx context.Context, e *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[e.Key()] = *e
+	return nil
+}
+
+func (m *memoryEntryBackup) Keys(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.entries))
+	for key := range m.entries {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *memoryEntryBackup) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memoryEntryBackup) Load(ctx context.Context, key string) (*Entry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	return &e, nil
+}
+
+type memoryExecutionStore struct {
+	mu      sync.RWMutex
+	running map[string]Execution
+	history map[string][]Execution // latest first
+
+	maxHistoryNum int64
+}
+
+func NewMemoryExecutionStore(maxHistoryNum int64) ExecutionStore {
+	if maxHistoryNum < 0 {
+		maxHistoryNum = 0
+	}
+	return &memoryExecutionStore{
+		running:       make(map[string]Execution),
+		history:       make(map[string][]Execution),
+		maxHistoryNum: maxHistoryNum,
+	}
+}
+
+func (m *memoryExecutionStore) Begin(ctx context.Context, e *Execution) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.running[e.ID.String()] = *e
+
+	history := append([]Execution{*e}, m.history[e.Name]...)
+	if int64(len(history)) > m.maxHistoryNum {
+		history = history[:m.maxHistoryNum]
+	}
+	m.history[e.Name] = history
+	return nil
+}
+
+func (m *memoryExecutionStore) Finish(ctx context.Context, e *Execution) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.running, e.ID.String())
+
+	history := m.history[e.Name]
+	for i := range history {
+		if history[i].ID == e.ID {
+			history[i] = *e
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryExecutionStore) Running(ctx context.Context) ([]Execution, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	executions := make([]Execution, 0, len(m.running))
+	for _, e := range m.running {
+		executions = append(executions, e)
+	}
+	return executions, nil
+}
+
+func (m *memoryExecutionStore) History(ctx context.Context, jobName string, offset, size int64) ([]Execution, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history := m.history[jobName]
+	if offset < 0 || offset >= int64(len(history)) || size <= 0 {
+		return []Execution{}, nil
+	}
+
+	end := offset + size
+	if end > int64(len(history)) {
+		end = int64(len(history))
+	}
+	return append([]Execution{}, history[offset:end]...), nil
+}
@@ -0,0 +1,29 @@
+package cron_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/yinyajun/cron"
+)
+
+func TestMemoryExecutionStoreNoHistory(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		store = cron.NewMemoryExecutionStore(-1)
+	)
+
+	e := &cron.Execution{ID: uuid.New(), Name: "job"}
+	if err := store.Begin(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	history, err := store.History(ctx, "job", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("history of %d executions, want none", len(history))
+	}
+}
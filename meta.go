@@ -0,0 +1,176 @@
+package cron
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+)
+
+// AgentVersion is the software version advertised to the other nodes
+const AgentVersion = "v0.2.0"
+
+// NodeMeta describes the capabilities of a node to the other nodes
+type NodeMeta struct {
+	HttpAddr string              `json:"http_addr,omitempty"`
+	Version  string              `json:"version,omitempty"`
+	Roles    []string            `json:"roles,omitempty"`
+	Labels   map[string]string   `json:"labels,omitempty"`
+	Load     int64               `json:"load"`               // running executions
+	Key      string              `json:"key,omitempty"`      // fingerprint of the primary gossip key
+	Keys     []string            `json:"keys,omitempty"`     // fingerprints of all the gossip keys
+	Protocol int                 `json:"protocol,omitempty"` // gossip protocol version spoken
+	Jobs     map[string][]string `json:"jobs,omitempty"`     // namespace -> registered jobs
+	// Truncated is set if some jobs, keys, labels or roles are left out to
+	// fit the size limit
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// encode encodes the meta in at most limit bytes, jobs of the largest
+// namespaces are left out first if it does not fit, then the keys, labels
+// and roles. The protocol is always kept.
+func (m NodeMeta) encode(limit int) []byte {
+	b, _ := json.Marshal(m)
+	if len(b) <= limit {
+		return b
+	}
+
+	jobs := make(map[string][]string, len(m.Jobs))
+	for ns, names := range m.Jobs {
+		jobs[ns] = append([]string(nil), names...)
+	}
+	m.Jobs = jobs
+	m.Truncated = true
+
+	for len(b) > limit && len(m.Jobs) > 0 {
+		largest := ""
+		for ns, names := range m.Jobs {
+			if largest == "" || len(names) > len(m.Jobs[largest]) {
+				largest = ns
+			}
+		}
+		if names := m.Jobs[largest]; len(names) > 0 {
+			m.Jobs[largest] = names[:len(names)-1]
+		} else {
+			delete(m.Jobs, largest)
+		}
+		b, _ = json.Marshal(m)
+	}
+
+	if len(b) <= limit {
+		return b
+	}
+
+	// nodes without the keys refuse to use a new key, and those without the
+	// labels are not eligible to entries requiring labels
+	Logger.Warnf("node meta exceeds %d bytes without the jobs, keys, labels and roles are left out: %s", limit, b)
+	for _, drop := range []func(){
+		func() { m.Keys = nil },
+		func() { m.Labels = nil },
+		func() { m.Roles = nil },
+		func() { m.HttpAddr = "" },
+	} {
+		drop()
+		if b, _ = json.Marshal(m); len(b) <= limit {
+			return b
+		}
+	}
+
+	b, _ = json.Marshal(NodeMeta{Protocol: m.Protocol, Truncated: true})
+	return b
+}
+
+func (m *NodeMeta) labels() map[string]string {
+	if m == nil {
+		return nil
+	}
+	return m.Labels
+}
+
+// hasKey reports whether the node has the gossip key of fingerprint
+func (m *NodeMeta) hasKey(fingerprint string) bool {
+	if m == nil {
+		return false
+	}
+	for _, k := range m.Keys {
+		if k == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeNodeMeta(b []byte) *NodeMeta {
+	if len(b) == 0 {
+		return nil
+	}
+
+	m := &NodeMeta{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// nodeMeta collects the meta of the local node
+func (a *Agent) nodeMeta() NodeMeta {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	keys := a.gossipKeys()
+	meta := NodeMeta{
+		HttpAddr: a.httpAddr(),
+		Version:  AgentVersion,
+		Roles:    a.roles,
+		Labels:   a.labels,
+		Key:      keys.Primary,
+		Keys:     keys.Keys,
+		Jobs:     make(map[string][]string, len(a.namespaces)),
+	}
+	for name, n := range a.namespaces {
+		meta.Load += n.executor.Load()
+
+		jobs := n.Jobs()
+		if len(jobs) == 0 {
+			continue
+		}
+		sort.Strings(jobs)
+		meta.Jobs[name] = jobs
+	}
+	return meta
+}
+
+// httpAddr is the http address advertised to the other nodes, the host of
+// the gossip address, or the hostname, if the server listens on all
+// interfaces
+func (a *Agent) httpAddr() string {
+	host, port, err := net.SplitHostPort(a.server.Addr)
+	if err != nil {
+		return a.server.Addr
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return a.server.Addr
+	}
+
+	if h, _, err := net.SplitHostPort(a.entries.LocalMember().Addr); err == nil {
+		host = h
+	} else if h, err := os.Hostname(); err == nil {
+		host = h
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// refreshMeta advertises the meta of the local node if it has changed
+func (a *Agent) refreshMeta() {
+	meta := a.nodeMeta()
+
+	ser, _ := json.Marshal(meta)
+	a.metaMu.Lock()
+	changed := string(ser) != a.lastMeta
+	a.lastMeta = string(ser)
+	a.metaMu.Unlock()
+
+	if changed {
+		a.entries.UpdateMeta(meta)
+	}
+}
@@ -0,0 +1,87 @@
+package cron
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNodeMetaEncode(t *testing.T) {
+	const limit = 512
+
+	jobs := map[string][]string{"default": {"a", "b"}, "team": nil}
+	for i := 0; i < 100; i++ {
+		jobs["team"] = append(jobs["team"], fmt.Sprintf("job-%03d", i))
+	}
+	labels := map[string]string{"zone": "a", "big": strings.Repeat("x", 600)}
+
+	for _, c := range []struct {
+		name      string
+		meta      NodeMeta
+		truncated bool
+		keys      bool
+		labels    bool
+	}{
+		{"fits", NodeMeta{Protocol: 1, Keys: []string{"k"}, Labels: map[string]string{"zone": "a"}, Jobs: map[string][]string{"default": {"a"}}}, false, true, true},
+		{"jobs", NodeMeta{Protocol: 1, Keys: []string{"k"}, Labels: map[string]string{"zone": "a"}, Jobs: jobs}, true, true, true},
+		{"keys", NodeMeta{Protocol: 1, Keys: []string{strings.Repeat("k", 600)}, Labels: map[string]string{"zone": "a"}, Jobs: jobs}, true, false, true},
+		{"labels", NodeMeta{Protocol: 1, Keys: []string{"k"}, Labels: labels, Jobs: jobs}, true, false, false},
+		{"roles", NodeMeta{Protocol: 1, Roles: []string{strings.Repeat("r", 600)}}, true, false, false},
+	} {
+		b := c.meta.encode(limit)
+		if len(b) == 0 || len(b) > limit {
+			t.Fatalf("%s: %d bytes encoded, limit %d", c.name, len(b), limit)
+		}
+
+		m := decodeNodeMeta(b)
+		if m == nil {
+			t.Fatalf("%s: invalid meta %s", c.name, b)
+		}
+		if m.Protocol != 1 {
+			t.Errorf("%s: protocol %d, want 1", c.name, m.Protocol)
+		}
+		if m.Truncated != c.truncated {
+			t.Errorf("%s: truncated %v, want %v", c.name, m.Truncated, c.truncated)
+		}
+		if (len(m.Keys) > 0) != c.keys {
+			t.Errorf("%s: keys %v", c.name, m.Keys)
+		}
+		if (len(m.Labels) > 0) != c.labels {
+			t.Errorf("%s: labels %v", c.name, m.Labels)
+		}
+	}
+
+	// the jobs of the largest namespace are left out first
+	m := decodeNodeMeta(NodeMeta{Jobs: jobs}.encode(limit))
+	if len(m.Jobs["default"]) != 2 || len(m.Jobs["team"]) >= 100 {
+		t.Fatalf("jobs %v", m.Jobs)
+	}
+	if len(jobs["team"]) != 100 {
+		t.Fatal("jobs of the meta changed")
+	}
+}
+
+func TestAgentHttpAddr(t *testing.T) {
+	a := newTestAgent(t)
+
+	for addr, want := range map[string]string{
+		"127.0.0.1:8080": "127.0.0.1:8080",
+		"node-1:8080":    "node-1:8080",
+		"[::1]:8080":     "[::1]:8080",
+	} {
+		a.server.Addr = addr
+		if got := a.httpAddr(); got != want {
+			t.Errorf("%s advertised as %s, want %s", addr, got, want)
+		}
+	}
+
+	// the host is filled in when listening on all interfaces
+	for _, addr := range []string{":8080", "0.0.0.0:8080", "[::]:8080"} {
+		a.server.Addr = addr
+		host, port, err := net.SplitHostPort(a.httpAddr())
+		if err != nil || host == "" || net.ParseIP(host).IsUnspecified() || port != "8080" {
+			t.Errorf("%s advertised as %s", addr, a.httpAddr())
+		}
+	}
+}
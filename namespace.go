@@ -0,0 +1,209 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNamespaceInvalid  = errors.New("invalid namespace")
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	ErrNamespaceConflict = errors.New("namespace conflicts with a job of the default namespace")
+)
+
+// Namespace is an isolated scheduler served by the agent. Jobs, entries,
+// timeline and execution history of a namespace are invisible to the others.
+type Namespace struct {
+	name     string
+	cron     *Cron
+	executor *Executor
+	stream   *ChangeStream
+
+	// onRegister is called after jobs are registered
+	onRegister func()
+	// checkJob checks the name of a job of the default namespace, nil for
+	// the other namespaces
+	checkJob func(name string) error
+
+	// ctx is canceled on agent shutdown, aborting the pending api calls
+	ctx context.Context
+}
+
+// namespacePattern restricts namespace names to characters which are neither
+// a separator of entry keys nor of storage keys, so that no two namespaces
+// share a key.
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+func validNamespace(name string) bool {
+	return namespacePattern.MatchString(name)
+}
+
+// namespaceKey derives the storage key of a namespace from key, the default
+// namespace keeps the key unchanged.
+func namespaceKey(key, namespace string) string {
+	if namespace == DefaultNamespace {
+		return key
+	}
+	return key + ":" + namespace
+}
+
+func (n *Namespace) Name() string { return n.name }
+
+func (n *Namespace) Register(jobs ...Job) error {
+	if n.onRegister != nil {
+		defer n.onRegister()
+	}
+
+	for _, job := range jobs {
+		if err := n.register(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Namespace) register(job Job) error {
+	if job.Name() == "" {
+		return ErrJobNameEmpty
+	}
+	// "/" separates the namespace from the job name in entry keys, jobs of
+	// the default namespace keep their names as before namespaces
+	if n.checkJob != nil {
+		if err := n.checkJob(job.Name()); err != nil {
+			return err
+		}
+	} else if strings.Contains(job.Name(), "/") {
+		return ErrJobNameInvalid
+	}
+	n.executor.Register(job)
+	return nil
+}
+
+func (n *Namespace) Add(spec, jobName string) error {
+	if err := n.validate(jobName); err != nil {
+		return err
+	}
+
+	return n.cron.Add(n.ctx, spec, jobName)
+}
+
+// AddWithPlacement adds a job run only on the nodes allowed by p
+func (n *Namespace) AddWithPlacement(spec, jobName string, p *Placement) error {
+	if err := n.validate(jobName); err != nil {
+		return err
+	}
+
+	return n.cron.AddWithPlacement(n.ctx, spec, jobName, p)
+}
+
+func (n *Namespace) Active(jobName string) error {
+	if err := n.validate(jobName); err != nil {
+		return err
+	}
+
+	return n.cron.Activate(n.ctx, jobName)
+}
+
+func (n *Namespace) Pause(jobName string) error {
+	if err := n.validate(jobName); err != nil {
+		return err
+	}
+
+	return n.cron.Pause(n.ctx, jobName)
+}
+
+func (n *Namespace) Remove(jobName string) error {
+	if err := n.validate(jobName); err != nil {
+		return err
+	}
+
+	return n.cron.Remove(n.ctx, jobName)
+}
+
+func (n *Namespace) ExecuteOnce(jobName string) error {
+	if err := n.validate(jobName); err != nil {
+		return err
+	}
+
+	go n.executor.executeTask(context.Background(), jobName)
+	Logger.Info("execute once:", entryKey(n.name, jobName))
+	return nil
+}
+
+func (n *Namespace) Schedule() ([]entryRecord, error) {
+	events, err := n.cron.Events(n.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results = make([]entryRecord, len(events))
+
+	for i, event := range events {
+		results[i] = entryRecord{
+			Name:      event.Name,
+			Next:      event.Time.Unix() * 1000,
+			Displayed: event.Displayed,
+		}
+		if e, ok := n.cron.entries.Get(entryKey(n.name, event.Name)); ok {
+			results[i].Spec = e.Spec
+			results[i].Placement = e.Placement
+		}
+	}
+	return results, nil
+}
+
+func (n *Namespace) Running() ([]Execution, error) {
+	return n.executor.Running(n.ctx)
+}
+
+func (n *Namespace) History(jobName string, offset, size int64) ([]Execution, int64, error) {
+	total := n.executor.maxHistoryNum
+	if jobName == "" {
+		return nil, total, ErrJobNameEmpty
+	}
+	executions, err := n.executor.History(n.ctx, jobName, offset, size)
+	return executions, total, err
+}
+
+// Changes tails the change stream of the timeline, see ChangeStream.Read
+func (n *Namespace) Changes(id string, count int64, block time.Duration) ([]Change, error) {
+	if n.stream == nil {
+		return nil, ErrStreamDisabled
+	}
+	return n.stream.Read(n.ctx, id, count, block)
+}
+
+func (n *Namespace) Jobs() []string {
+	return n.executor.Jobs()
+}
+
+func (n *Namespace) validate(jobName string) error {
+	if jobName == "" {
+		return ErrJobNameEmpty
+	}
+	if !n.executor.Contain(jobName) {
+		return ErrJobNotSupport
+	}
+	return nil
+}
+
+func (n *Namespace) run() {
+	go n.executor.consume()
+	go n.cron.run()
+}
+
+func (n *Namespace) close() {
+	n.cron.close()
+	n.executor.close()
+}
+
+type entryRecord struct {
+	Name      string     `json:"name"`
+	Spec      string     `json:"spec"`
+	Next      int64      `json:"next"`
+	Displayed bool       `json:"displayed"`
+	Placement *Placement `json:"placement,omitempty"`
+}
@@ -0,0 +1,66 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestValidNamespace(t *testing.T) {
+	for name, valid := range map[string]bool{
+		DefaultNamespace: true,
+		"teamA":          true,
+		"team-a":         true,
+		"":               false,
+		"team/a":         false,
+		"a_state":        false,
+		"a:b":            false,
+		"a b":            false,
+	} {
+		if validNamespace(name) != valid {
+			t.Errorf("validNamespace(%q) = %v, want %v", name, !valid, valid)
+		}
+	}
+}
+
+type testJob string
+
+func (j testJob) Name() string                                 { return string(j) }
+func (j testJob) Run(ctx context.Context) (interface{}, error) { return nil, nil }
+
+// newTestAgent creates a standalone agent on memory storage, not running
+func newTestAgent(t *testing.T) *Agent {
+	conf := &Conf{}
+	conf.Base.Storage = "memory"
+	conf.Base.Standalone = true
+	conf.Base.HttpAddr = "127.0.0.1:0"
+	conf.Gossip.NodeName = "node"
+	conf.WithDefault()
+
+	a := NewAgent(conf)
+	t.Cleanup(a.entries.Close)
+	return a
+}
+
+func TestJobNameSlash(t *testing.T) {
+	a := newTestAgent(t)
+
+	// the default namespace keeps the job names of older versions
+	if err := a.Register(testJob("team/x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Namespace("team"); !errors.Is(err, ErrNamespaceConflict) {
+		t.Fatalf("namespace team: %v, want a conflict with job team/x", err)
+	}
+
+	ns, err := a.Namespace("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Register(testJob("a/b")); !errors.Is(err, ErrJobNameInvalid) {
+		t.Fatalf("register a/b in namespace other: %v", err)
+	}
+	if err := a.Register(testJob("other/x")); !errors.Is(err, ErrJobNameInvalid) {
+		t.Fatalf("register other/x: %v, want a conflict with namespace other", err)
+	}
+}
@@ -0,0 +1,164 @@
+package cron
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+var (
+	ErrNoEligibleNode   = errors.New("no eligible node")
+	ErrPlacementInvalid = errors.New("invalid placement")
+)
+
+// membersCacheTTL limits how often the members are listed while claiming
+const membersCacheTTL = time.Second
+
+// Placement constrains the nodes an entry runs on, matched against the
+// labels of the nodes.
+type Placement struct {
+	// Node is the only node allowed if set
+	Node string `json:"node,omitempty"`
+	// Required labels the node must have
+	Required map[string]string `json:"required,omitempty"`
+	// Preferred labels select the nodes to run on if any alive one has them
+	Preferred map[string]string `json:"preferred,omitempty"`
+}
+
+func (p *Placement) allows(node string, labels map[string]string) bool {
+	if p == nil {
+		return true
+	}
+	if p.Node != "" && p.Node != node {
+		return false
+	}
+	return matchLabels(p.Required, labels)
+}
+
+func (p *Placement) prefers(labels map[string]string) bool {
+	return p != nil && len(p.Preferred) > 0 && matchLabels(p.Preferred, labels)
+}
+
+func matchLabels(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabels parses labels in the form of "k1=v1,k2=v2"
+func ParseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, ErrPlacementInvalid
+		}
+		labels[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return labels, nil
+}
+
+// placementPolicy makes only the nodes having registered a job, and allowed
+// by the placement of the entry, compete for it. The jobs and the labels of
+// the other nodes are known from their node meta.
+type placementPolicy struct {
+	namespace *Namespace
+	entries   EntryStore
+	labels    map[string]string // of the local node
+
+	mu        sync.Mutex
+	members   []Member
+	expiresAt time.Time
+}
+
+func newPlacementPolicy(n *Namespace, entries EntryStore, labels map[string]string) *placementPolicy {
+	return &placementPolicy{
+		namespace: n,
+		entries:   entries,
+		labels:    labels,
+	}
+}
+
+func (p *placementPolicy) Eligible(e Entry) (bool, bool) {
+	var (
+		local     = p.entries.LocalMember().Name
+		localOK   = p.namespace.executor.Contain(e.Name) && e.Placement.allows(local, p.labels)
+		remoteOK  bool
+		preferred bool // some eligible node has the preferred labels
+	)
+	if localOK {
+		preferred = e.Placement.prefers(p.labels)
+	}
+
+	for _, m := range p.alive() {
+		if m.Name == local || !p.eligible(m, e) {
+			continue
+		}
+		remoteOK = true
+
+		if e.Placement.prefers(m.Meta.labels()) {
+			preferred = true
+		}
+	}
+
+	// leave the entry to the preferred nodes
+	if preferred && localOK && !e.Placement.prefers(p.labels) {
+		localOK = false
+	}
+	return localOK, localOK || remoteOK
+}
+
+// Reject records a failed execution, as no node can run the entry
+func (p *placementPolicy) Reject(e Entry) {
+	Logger.Errorf("[%s] %s, the run is skipped", e.Key(), ErrNoEligibleNode)
+	metrics.IncrCounter([]string{"cron", "claim", "no_eligible_node"}, 1)
+	p.namespace.executor.reject(e.Name, ErrNoEligibleNode)
+}
+
+// eligible reports whether m may run e
+func (p *placementPolicy) eligible(m Member, e Entry) bool {
+	// nodes not advertising their meta may have the job, but their labels
+	// are unknown
+	if m.Meta == nil {
+		return e.Placement.allows(m.Name, nil)
+	}
+	if !e.Placement.allows(m.Name, m.Meta.Labels) {
+		return false
+	}
+
+	if m.Meta.Truncated {
+		return true
+	}
+	for _, name := range m.Meta.Jobs[p.namespace.name] {
+		if name == e.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *placementPolicy) alive() []Member {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now := time.Now(); now.After(p.expiresAt) {
+		p.members = p.members[:0]
+		for _, m := range p.entries.Members() {
+			if m.State == MemberAlive {
+				p.members = append(p.members, m)
+			}
+		}
+		p.expiresAt = now.Add(membersCacheTTL)
+	}
+	return p.members
+}
@@ -0,0 +1,114 @@
+package cron
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+// membersEntries is a standalone entry store listing the given members
+type membersEntries struct {
+	*LocalEntries
+	members []Member
+}
+
+func (s *membersEntries) Members() []Member { return s.members }
+
+func newTestPlacementPolicy(t *testing.T, labels map[string]string, members ...Member) *placementPolicy {
+	entries := &membersEntries{LocalEntries: NewLocalEntries(NewMemoryEntryBackup(), "a"), members: members}
+	t.Cleanup(entries.Close)
+
+	n := &Namespace{name: DefaultNamespace, executor: NewExecutor(NewMemoryExecutionStore(5), "a")}
+	n.executor.Register(testJob("job"))
+	return newPlacementPolicy(n, entries, labels)
+}
+
+func TestPlacementEligible(t *testing.T) {
+	var (
+		gpu       = map[string]string{"gpu": "true"}
+		required  = &Placement{Required: gpu}
+		preferred = &Placement{Preferred: gpu}
+		jobs      = map[string][]string{DefaultNamespace: {"job"}}
+	)
+
+	for _, c := range []struct {
+		name      string
+		labels    map[string]string // of the local node
+		member    Member
+		placement *Placement
+		local     bool
+		any       bool
+	}{
+		{"no placement", nil, Member{Name: "b", Meta: &NodeMeta{Jobs: jobs}}, nil, true, true},
+		{"required local", gpu, Member{Name: "b", Meta: &NodeMeta{Jobs: jobs}}, required, true, true},
+		{"required remote", nil, Member{Name: "b", Meta: &NodeMeta{Labels: gpu, Jobs: jobs}}, required, false, true},
+		{"required nowhere", nil, Member{Name: "b", Meta: &NodeMeta{Jobs: jobs}}, required, false, false},
+		{"required, job not registered remotely", nil, Member{Name: "b", Meta: &NodeMeta{Labels: gpu}}, required, false, false},
+		{"required, truncated meta", nil, Member{Name: "b", Meta: &NodeMeta{Labels: gpu, Truncated: true}}, required, false, true},
+		{"required, nil meta", nil, Member{Name: "b"}, required, false, false},
+		{"nil meta", nil, Member{Name: "b"}, nil, true, true},
+		{"node", nil, Member{Name: "b", Meta: &NodeMeta{Jobs: jobs}}, &Placement{Node: "b"}, false, true},
+		{"preferred local", gpu, Member{Name: "b", Meta: &NodeMeta{Jobs: jobs}}, preferred, true, true},
+		{"preferred remote", nil, Member{Name: "b", Meta: &NodeMeta{Labels: gpu, Jobs: jobs}}, preferred, false, true},
+		{"preferred nowhere", nil, Member{Name: "b", Meta: &NodeMeta{Jobs: jobs}}, preferred, true, true},
+		{"preferred, remote without the job", nil, Member{Name: "b", Meta: &NodeMeta{Labels: gpu}}, preferred, true, true},
+	} {
+		c.member.State = MemberAlive
+		p := newTestPlacementPolicy(t, c.labels, c.member)
+
+		local, any := p.Eligible(Entry{Name: "job", Placement: c.placement})
+		if local != c.local || any != c.any {
+			t.Errorf("%s: eligible %v, %v, want %v, %v", c.name, local, any, c.local, c.any)
+		}
+	}
+}
+
+func TestPlacementEligibleAliveOnly(t *testing.T) {
+	p := newTestPlacementPolicy(t, nil, Member{
+		Name:  "b",
+		State: MemberDead,
+		Meta:  &NodeMeta{Labels: map[string]string{"gpu": "true"}, Jobs: map[string][]string{DefaultNamespace: {"job"}}},
+	})
+
+	if _, any := p.Eligible(Entry{Name: "job", Placement: &Placement{Node: "b"}}); any {
+		t.Fatal("dead node eligible")
+	}
+}
+
+func TestPlacementReject(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.NewGlobal(conf, &metrics.BlackholeSink{})
+
+	p := newTestPlacementPolicy(t, nil)
+	e := Entry{Name: "job", Placement: &Placement{Node: "b"}}
+	if _, any := p.Eligible(e); any {
+		t.Fatal("entry eligible without the node")
+	}
+	p.Reject(e)
+
+	var skipped float64
+	for _, interval := range sink.Data() {
+		if c, ok := interval.Counters["cron.claim.no_eligible_node"]; ok {
+			skipped += c.Sum
+		}
+	}
+	if skipped != 1 {
+		t.Fatalf("%v runs counted as skipped, want 1", skipped)
+	}
+
+	history, err := p.namespace.executor.store.History(context.Background(), "job", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Success || history[0].Result != "Error: "+ErrNoEligibleNode.Error() {
+		t.Fatalf("history %+v, want the failed run", history)
+	}
+}
@@ -0,0 +1,111 @@
+package cron
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/memberlist"
+)
+
+var ErrProtocolUnsupported = errors.New("unsupported gossip protocol")
+
+// The gossip protocol versions:
+//
+//	0: actions are json, the push/pull state is the json of all the entries
+//	1: messages are framed, the push/pull state is a digest of the entries
+//
+// A node speaks ProtocolVersion and understands the messages of the versions
+// from ProtocolVersionMin. It advertises its version in node meta, and sends
+// in the lowest version spoken by the alive nodes, so a cluster keeps in
+// sync while it is upgraded node by node. Messages of newer versions or of
+// unknown kinds are dropped and counted.
+const (
+	ProtocolVersion    = 1
+	ProtocolVersionMin = 0
+)
+
+// A framed message is protocolMagic, the protocol version, the kind of the
+// message, then the body. The messages of version 0 are json objects, which
+// never start with protocolMagic.
+const protocolMagic byte = 0xc5
+
+const (
+	kindDigest  byte = 1 // flate compressed, see digest
+	kindEntries byte = 2 // flate compressed, see encodeEntries
+	kindAction  byte = 3 // json of Action
+	kindKey     byte = 4 // json of keyMsg
+)
+
+func compressed(kind byte) bool { return kind == kindDigest || kind == kindEntries }
+
+// isFramed reports whether b is a message of version 1 and later
+func isFramed(b []byte) bool {
+	return len(b) > 0 && b[0] == protocolMagic
+}
+
+func frame(kind byte, body []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{protocolMagic, ProtocolVersion, kind})
+
+	if !compressed(kind) {
+		b.Write(body)
+		return b.Bytes()
+	}
+
+	w, _ := flate.NewWriter(&b, flate.BestSpeed)
+	w.Write(body)
+	w.Close()
+	return b.Bytes()
+}
+
+// unframe returns the kind and the body of a framed message
+func unframe(b []byte) (byte, *bytes.Reader, error) {
+	if len(b) < 3 || b[0] != protocolMagic {
+		return 0, nil, ErrStateInvalid
+	}
+	if b[1] > ProtocolVersion {
+		return 0, nil, fmt.Errorf("%w: version %d", ErrProtocolUnsupported, b[1])
+	}
+
+	kind := b[2]
+	if !compressed(kind) {
+		return kind, bytes.NewReader(b[3:]), nil
+	}
+
+	body, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(b[3:])))
+	if err != nil {
+		return 0, nil, err
+	}
+	return kind, bytes.NewReader(body), nil
+}
+
+// drop counts a message which can not be handled
+func drop(from string, err error) {
+	Logger.Warnf("drop message by %s: %s", from, err.Error())
+	metrics.IncrCounter([]string{"cron", "gossip", "dropped"}, 1)
+}
+
+// protocol is the lowest protocol version spoken by the alive nodes, nodes
+// not advertising it speak version 0
+func (s *GossipEntries) protocol() int {
+	var (
+		version = ProtocolVersion
+		local   = s.list.LocalNode().Name
+	)
+	for _, node := range s.list.Members() {
+		if node.Name == local || node.State != memberlist.StateAlive {
+			continue
+		}
+
+		var meta NodeMeta
+		if err := json.Unmarshal(node.Meta, &meta); err != nil || meta.Protocol < version {
+			version = meta.Protocol
+		}
+	}
+	return version
+}
@@ -0,0 +1,194 @@
+package cron
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+func TestFrame(t *testing.T) {
+	body := []byte(`{"type":1,"entry":{"name":"job"}}`)
+
+	for _, kind := range []byte{kindDigest, kindEntries, kindAction, kindKey} {
+		b := frame(kind, body)
+		if !isFramed(b) {
+			t.Fatalf("kind %d: framed message not recognized", kind)
+		}
+
+		got, r, err := unframe(b)
+		if err != nil {
+			t.Fatalf("kind %d: %v", kind, err)
+		}
+		unframed, _ := ioutil.ReadAll(r)
+		if got != kind || !bytes.Equal(unframed, body) {
+			t.Fatalf("kind %d: unframed kind %d, body %s", kind, got, unframed)
+		}
+	}
+
+	// messages of protocol 0 are json
+	for _, b := range [][]byte{nil, body, []byte(`[]`)} {
+		if isFramed(b) {
+			t.Fatalf("%s taken for a framed message", b)
+		}
+	}
+}
+
+func TestUnframeInvalid(t *testing.T) {
+	newer := frame(kindAction, []byte(`{}`))
+	newer[1] = ProtocolVersion + 1
+
+	corrupt := frame(kindDigest, []byte("digest"))
+	corrupt = append(corrupt[:3], 0xff, 0xff, 0xff)
+
+	for _, c := range []struct {
+		name string
+		b    []byte
+		err  error
+	}{
+		{"empty", nil, ErrStateInvalid},
+		{"short", []byte{protocolMagic, ProtocolVersion}, ErrStateInvalid},
+		{"json", []byte(`{"type":1}`), ErrStateInvalid},
+		{"newer version", newer, ErrProtocolUnsupported},
+		{"corrupt", corrupt, nil},
+	} {
+		_, _, err := unframe(c.b)
+		if err == nil || (c.err != nil && !errors.Is(err, c.err)) {
+			t.Errorf("%s: %v, want %v", c.name, err, c.err)
+		}
+	}
+
+	// unknown kinds are dropped by the receiver
+	unknown := frame(0x7f, []byte(`{}`))
+	if kind, _, err := unframe(unknown); err != nil || kind != 0x7f {
+		t.Fatalf("unknown kind %d, %v", kind, err)
+	}
+}
+
+// metaDelegate is a node advertising the given meta, a node of an older
+// version if it does not advertise its protocol
+type metaDelegate struct {
+	mu   sync.Mutex
+	meta []byte
+}
+
+func (d *metaDelegate) NodeMeta(limit int) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.meta
+}
+
+func (d *metaDelegate) NotifyMsg([]byte)                           {}
+func (d *metaDelegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d *metaDelegate) LocalState(join bool) []byte                { return nil }
+func (d *metaDelegate) MergeRemoteState(buf []byte, join bool)     {}
+
+func newTestMetaNode(t *testing.T, meta []byte) (*memberlist.Memberlist, *metaDelegate) {
+	d := &metaDelegate{meta: meta}
+
+	config := memberlist.DefaultLocalConfig()
+	config.Name = "peer"
+	config.BindAddr = "127.0.0.1"
+	config.BindPort = 0
+	config.Delegate = d
+	config.Logger = log.New(ioutil.Discard, "", 0)
+
+	list, err := memberlist.Create(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { list.Shutdown() })
+	return list, d
+}
+
+func TestProtocol(t *testing.T) {
+	s := newTestGossipEntries(t, nil)
+	if v := s.protocol(); v != ProtocolVersion {
+		t.Fatalf("protocol %d alone, want %d", v, ProtocolVersion)
+	}
+
+	peer, d := newTestMetaNode(t, nil)
+	if _, err := peer.Join([]string{s.LocalMember().Addr}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return len(s.Members()) == 2 }, "peer not joined")
+
+	for _, c := range []struct {
+		name string
+		meta []byte
+		want int
+	}{
+		{"missing meta", nil, 0},
+		{"meta of protocol 1", []byte(`{"protocol":1}`), 1},
+		{"invalid meta", []byte(`not json`), 0},
+		{"meta of protocol 1 again", []byte(`{"protocol":1}`), 1},
+		{"meta without protocol", []byte(`{"version":"v0.1.0","load":0}`), 0},
+	} {
+		d.mu.Lock()
+		d.meta = c.meta
+		d.mu.Unlock()
+		if err := peer.UpdateNode(time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		eventually(t, func() bool { return s.protocol() == c.want }, c.name+": protocol not detected")
+	}
+}
+
+func TestLegacyPeer(t *testing.T) {
+	s := newTestGossipEntries(t, nil)
+	s.Add(&Entry{Name: "job", Spec: testSpec, Version: s.NewVersion()})
+	s.Add(&Entry{Namespace: "team", Name: "job", Spec: testSpec, Version: s.NewVersion()})
+
+	peer, _ := newTestMetaNode(t, nil)
+	if _, err := peer.Join([]string{s.LocalMember().Addr}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return len(s.Members()) == 2 }, "peer not joined")
+
+	// the full state holds the default namespace only
+	state := s.LocalState(false)
+	if isFramed(state) {
+		t.Fatal("framed state sent to a node of protocol 0")
+	}
+	var entries map[string]*Entry
+	if err := json.Unmarshal(state, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries["job"] == nil {
+		t.Fatalf("state %s, want the default namespace only", state)
+	}
+
+	// actions on other namespaces are framed, dropped by the node
+	s.Broadcast(Action{Type: addType, Entry: &Entry{Name: "job", Spec: testSpec, Version: s.NewVersion()}})
+	s.Broadcast(Action{Type: addType, Entry: &Entry{Namespace: "team", Name: "job", Spec: testSpec, Version: s.NewVersion()}})
+
+	var plain, framed int
+	for _, msg := range s.GetBroadcasts(0, 1<<20) {
+		if isFramed(msg) {
+			framed++
+		} else {
+			plain++
+		}
+	}
+	if plain != 1 || framed != 1 {
+		t.Fatalf("%d plain and %d framed broadcasts, want 1 each", plain, framed)
+	}
+
+	// the state and the actions of the node are merged
+	legacy, _ := json.Marshal(map[string]*Entry{"old": {Name: "old", Spec: testSpec}})
+	s.MergeRemoteState(legacy, false)
+	if _, ok := s.Get("old"); !ok {
+		t.Fatal("state of protocol 0 not merged")
+	}
+	s.NotifyMsg(marshalAction(removeType, &Entry{Name: "old", Version: s.NewVersion()}))
+	if e, ok := s.Get("old"); !ok || !e.Deleted {
+		t.Fatal("action of protocol 0 not applied")
+	}
+}
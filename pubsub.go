@@ -0,0 +1,345 @@
+package cron
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var _ EntryStore = (*RedisEntries)(nil)
+
+const (
+	redisHeartbeat    = 2 * time.Second
+	redisDeadAfter    = 5 * redisHeartbeat
+	redisForgetAfter  = 60 * redisHeartbeat
+	redisSyncInterval = 30 * time.Second
+)
+
+// RedisEntries syncs entries through redis, for the networks where the
+// gossip ports can not be opened between nodes:
+//
+//	key:store   -> hash, entry key -> json of the entry, deleted ones included
+//	key:events  -> pub/sub channel of actions
+//	key:members -> hash, node -> json of redisMember
+//
+// Actions are published, and the full state is synced periodically and
+// every time the subscription is (re)established, so the actions missed
+// during a disconnection are caught up.
+type RedisEntries struct {
+	*entrySet
+	*memberLog
+
+	cli        redis.UniversalClient
+	storeKey   string
+	channel    string
+	membersKey string
+	node       string
+
+	metaMu sync.RWMutex
+	meta   NodeMeta
+
+	statesMu sync.Mutex
+	states   map[string]string // node -> last seen member state
+
+	pubsub *redis.PubSub
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type redisMember struct {
+	Seen int64     `json:"seen"` // unix milliseconds of the last heartbeat
+	Meta *NodeMeta `json:"meta,omitempty"`
+}
+
+func (m redisMember) seen() time.Time { return time.Unix(0, m.Seen*int64(time.Millisecond)) }
+
+func NewRedisEntries(
+	backup EntryBackup,
+	cli redis.UniversalClient,
+	key string,
+	node string,
+) *RedisEntries {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &RedisEntries{
+		entrySet:  newEntrySet(backup, node),
+		memberLog: newMemberLog(),
+		states:    make(map[string]string),
+
+		cli:        cli,
+		storeKey:   key + ":store",
+		channel:    key + ":events",
+		membersKey: key + ":members",
+		node:       node,
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if err := s.heartbeat(); err != nil {
+		Logger.Fatalln(err)
+	}
+
+	s.pubsub = cli.Subscribe(ctx, s.channel)
+
+	s.wg.Add(2)
+	go s.subscribe()
+	go s.loop()
+
+	return s
+}
+
+// Join has nothing to do, all the nodes meet in redis.
+func (s *RedisEntries) Join(existing []string) {}
+
+func (s *RedisEntries) Broadcast(u Action) {
+	if u.Entry == nil {
+		return
+	}
+
+	// the full state holds the local entry, which is marked deleted on remove
+	e, ok := s.Get(u.Entry.Key())
+	if !ok {
+		e = *u.Entry
+	}
+
+	state, _ := json.Marshal(e)
+	msg, _ := json.Marshal(u)
+
+	ctx, cancel := context.WithTimeout(s.ctx, redisHeartbeat)
+	defer cancel()
+
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.storeKey, e.Key(), state)
+		pipe.Publish(ctx, s.channel, msg)
+		return nil
+	})
+	if err != nil {
+		Logger.Error("broadcast failed: ", err.Error())
+	}
+}
+
+func (s *RedisEntries) UpdateMeta(meta NodeMeta) {
+	s.metaMu.Lock()
+	s.meta = meta
+	s.metaMu.Unlock()
+
+	if err := s.heartbeat(); err != nil {
+		Logger.Warn("update node meta failed: ", err.Error())
+	}
+}
+
+func (s *RedisEntries) LocalMember() Member {
+	s.metaMu.RLock()
+	meta := s.meta
+	s.metaMu.RUnlock()
+
+	return Member{Name: s.node, State: MemberAlive, Meta: &meta}
+}
+
+func (s *RedisEntries) Members() []Member {
+	ctx, cancel := context.WithTimeout(s.ctx, redisHeartbeat)
+	defer cancel()
+
+	nodes, err := s.cli.HGetAll(ctx, s.membersKey).Result()
+	if err != nil {
+		Logger.Error("members failed: ", err.Error())
+		return []Member{s.LocalMember()}
+	}
+
+	var (
+		members = make([]Member, 0, len(nodes))
+		now     = time.Now()
+	)
+	for name, ser := range nodes {
+		var m redisMember
+		if err := json.Unmarshal([]byte(ser), &m); err != nil {
+			continue
+		}
+
+		member := Member{Name: name, State: MemberAlive, Meta: m.Meta}
+		if now.Sub(m.seen()) > redisDeadAfter {
+			member.State = MemberDead
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+func (s *RedisEntries) Close() {
+	s.entrySet.close()
+	s.cancel()
+	s.pubsub.Close()
+	s.wg.Wait()
+
+	// leave the cluster
+	ctx, cancel := context.WithTimeout(context.Background(), redisHeartbeat)
+	defer cancel()
+	s.cli.HDel(ctx, s.membersKey, s.node)
+	s.cli.Close()
+}
+
+func (s *RedisEntries) subscribe() {
+	defer s.wg.Done()
+
+	for msg := range s.pubsub.ChannelWithSubscriptions(s.ctx, 100) {
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				s.sync()
+			}
+		case *redis.Message:
+			if err := s.apply([]byte(m.Payload), "pub/sub"); err != nil {
+				Logger.Warn("drop message by pub/sub: ", err.Error())
+			}
+		}
+	}
+}
+
+func (s *RedisEntries) loop() {
+	defer s.wg.Done()
+
+	heartbeat := time.NewTicker(redisHeartbeat)
+	defer heartbeat.Stop()
+	resync := time.NewTicker(redisSyncInterval)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-heartbeat.C:
+			if err := s.heartbeat(); err != nil {
+				Logger.Error("heartbeat failed: ", err.Error())
+			}
+		case <-resync.C:
+			s.sync()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// heartbeat refreshes the local node, forgets the nodes gone long ago, and
+// records the membership changes since the last heartbeat.
+func (s *RedisEntries) heartbeat() error {
+	ctx, cancel := context.WithTimeout(s.ctx, redisHeartbeat)
+	defer cancel()
+
+	s.metaMu.RLock()
+	meta := s.meta
+	s.metaMu.RUnlock()
+
+	now := time.Now()
+	ser, _ := json.Marshal(redisMember{Seen: now.UnixNano() / int64(time.Millisecond), Meta: &meta})
+	if err := s.cli.HSet(ctx, s.membersKey, s.node, ser).Err(); err != nil {
+		return err
+	}
+
+	nodes, err := s.cli.HGetAll(ctx, s.membersKey).Result()
+	if err != nil {
+		return err
+	}
+
+	states := make(map[string]string, len(nodes))
+	for name, ser := range nodes {
+		var m redisMember
+		if err := json.Unmarshal([]byte(ser), &m); err != nil {
+			continue
+		}
+
+		switch seen := now.Sub(m.seen()); {
+		case seen > redisForgetAfter:
+			s.cli.HDel(ctx, s.membersKey, name)
+		case seen > redisDeadAfter:
+			states[name] = MemberDead
+		default:
+			states[name] = MemberAlive
+		}
+	}
+	s.observe(states)
+	return nil
+}
+
+// observe records the changes from the last seen member states
+func (s *RedisEntries) observe(states map[string]string) {
+	s.statesMu.Lock()
+	defer s.statesMu.Unlock()
+
+	for name, state := range states {
+		if state == MemberAlive && s.states[name] != MemberAlive {
+			s.record(MemberJoin, Member{Name: name, State: state})
+		}
+		if state == MemberDead && s.states[name] == MemberAlive {
+			s.record(MemberFail, Member{Name: name, State: state})
+		}
+	}
+	for name, state := range s.states {
+		// removed on leaving
+		if _, ok := states[name]; !ok && state == MemberAlive {
+			s.record(MemberLeave, Member{Name: name, State: MemberLeft})
+		}
+	}
+	s.states = states
+}
+
+// Input:
+// KEYS[1] -> store key
+// ARGV[1] -> entry key
+// ARGV[2] -> json of the expired tombstone
+//
+// Output:
+// Returns 1 if the tombstone is purged, 0 if the entry has changed
+var purgeCmd = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// sync merges the full state in redis, and writes back the local entries
+// missing or outdated there.
+func (s *RedisEntries) sync() {
+	ctx, cancel := context.WithTimeout(s.ctx, redisHeartbeat)
+	defer cancel()
+
+	remotes, err := s.cli.HGetAll(ctx, s.storeKey).Result()
+	if err != nil {
+		Logger.Error("sync failed: ", err.Error())
+		return
+	}
+
+	var (
+		versions = make(map[string]Version, len(remotes))
+		ttl      = s.ttl()
+		now      = time.Now()
+	)
+	for key, ser := range remotes {
+		r := &Entry{}
+		if err := json.Unmarshal([]byte(ser), r); err != nil {
+			continue
+		}
+		s.mergeEntry(r, "sync")
+
+		if s.expired(r, ttl, now) {
+			if err := purgeCmd.Run(ctx, s.cli, []string{s.storeKey}, key, ser).Err(); err != nil {
+				Logger.Error("sync failed: ", err.Error())
+			}
+			continue
+		}
+		versions[key] = r.Version
+	}
+
+	for key, e := range s.Entries() {
+		if v, ok := versions[key]; ok && !v.Less(e.Version) {
+			continue
+		}
+		state, _ := json.Marshal(e)
+		if err := s.cli.HSet(ctx, s.storeKey, key, state).Err(); err != nil {
+			Logger.Error("sync failed: ", err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// eventually fails unless cond holds within a few seconds
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestRedisEntries(t *testing.T, s *miniredis.Miniredis, node string) *RedisEntries {
+	cli := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	entries := NewRedisEntries(NewMemoryEntryBackup(), cli, "_entries", node)
+	t.Cleanup(entries.Close)
+	return entries
+}
+
+func TestRedisEntries(t *testing.T) {
+	var (
+		s    = miniredis.RunT(t)
+		a, b = newTestRedisEntries(t, s, "a"), newTestRedisEntries(t, s, "b")
+	)
+
+	e := &Entry{Name: "job", Spec: "* * * * * *", Version: a.NewVersion()}
+	a.Add(e)
+	a.Broadcast(Action{Type: addType, Entry: e})
+	eventually(t, func() bool {
+		r, ok := b.Get("job")
+		return ok && r.Version == e.Version
+	}, "add not published")
+
+	version := b.NewVersion()
+	b.Remove("job", version)
+	b.Broadcast(Action{Type: removeType, Entry: &Entry{Name: "job", Version: version}})
+	eventually(t, func() bool {
+		r, ok := a.Get("job")
+		return ok && r.Deleted && r.Version == version
+	}, "remove not published")
+
+	// a node started later catches up with the full state
+	c := newTestRedisEntries(t, s, "c")
+	eventually(t, func() bool {
+		r, ok := c.Get("job")
+		return ok && r.Deleted
+	}, "state not synced")
+
+	if members := c.Members(); len(members) != 3 {
+		t.Fatalf("members %v, want a, b and c", members)
+	}
+}
@@ -0,0 +1,239 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+// ReconcileReport is the result of one pass of the reconciler, entries are
+// identified by Entry.Key().
+type ReconcileReport struct {
+	Time int64 `json:"time"` // unix milliseconds
+
+	// in the timeline, without backup nor entry, never run
+	OrphanedTimeline []string `json:"orphaned_timeline"`
+	// in the backup, not in the timeline of its namespace
+	OrphanedBackup []string `json:"orphaned_backup"`
+	// only known in memory, neither in the timeline nor in the backup
+	LocalOnly []string `json:"local_only"`
+	// repaired: backups saved from memory, and backups of removed entries
+	// deleted
+	Repaired []string `json:"repaired"`
+
+	Err string `json:"error,omitempty"`
+}
+
+// reconciler checks periodically that the timelines, the entry backup and
+// the entries in memory agree. What can be safely derived from the others
+// is repaired, the rest is reported.
+type reconciler struct {
+	agent    *Agent
+	interval time.Duration
+
+	mu   sync.RWMutex
+	last ReconcileReport
+}
+
+func (r *reconciler) run(ctx context.Context) {
+	if r.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.reconcile(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *reconciler) report() ReconcileReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.last
+}
+
+func (r *reconciler) reconcile(ctx context.Context) ReconcileReport {
+	report := ReconcileReport{Time: time.Now().UnixNano() / int64(time.Millisecond)}
+	if err := r.check(ctx, &report); err != nil {
+		report.Err = err.Error()
+		Logger.Error("reconcile failed: ", err.Error())
+	}
+
+	for _, keys := range [][]string{report.OrphanedTimeline, report.OrphanedBackup, report.LocalOnly, report.Repaired} {
+		sort.Strings(keys)
+	}
+
+	metrics.SetGauge([]string{"cron", "reconcile", "orphaned_timeline"}, float32(len(report.OrphanedTimeline)))
+	metrics.SetGauge([]string{"cron", "reconcile", "orphaned_backup"}, float32(len(report.OrphanedBackup)))
+	metrics.SetGauge([]string{"cron", "reconcile", "local_only"}, float32(len(report.LocalOnly)))
+	metrics.IncrCounter([]string{"cron", "reconcile", "repaired"}, float32(len(report.Repaired)))
+
+	if n := len(report.OrphanedTimeline) + len(report.OrphanedBackup) + len(report.LocalOnly); n > 0 {
+		Logger.Warnf("reconcile: orphaned timeline %v, orphaned backup %v, local only %v",
+			report.OrphanedTimeline, report.OrphanedBackup, report.LocalOnly)
+	}
+	if len(report.Repaired) > 0 {
+		Logger.Infof("reconcile: repaired %v", report.Repaired)
+	}
+
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+	return report
+}
+
+func (r *reconciler) check(ctx context.Context, report *ReconcileReport) error {
+	a := r.agent
+	backup := a.storage.backup
+
+	// the timelines are read before the backup: an entry added in between
+	// is in the backup only, which is reported but not repaired. An entry
+	// removed in between may be in the timeline without backup, see
+	// saveLive.
+	timeline := make(map[string]bool)
+	a.mu.Lock()
+	namespaces := make(map[string]*Namespace, len(a.namespaces))
+	for name, n := range a.namespaces {
+		namespaces[name] = n
+	}
+	a.mu.Unlock()
+
+	for name, n := range namespaces {
+		events, err := n.cron.Events(ctx)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			timeline[entryKey(name, event.Name)] = true
+		}
+	}
+
+	tctx, cancel := withTimeout(ctx, a.custom.timeout)
+	keys, err := backup.Keys(tctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	backups := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		backups[key] = true
+	}
+
+	entries := a.entries.Entries()
+
+	for key := range timeline {
+		if backups[key] {
+			continue
+		}
+		e, ok := entries[key]
+		if !ok || e.Deleted {
+			report.OrphanedTimeline = append(report.OrphanedTimeline, key)
+			continue
+		}
+
+		saved, err := r.saveLive(ctx, namespaces[namespaceOfKey(key)], e)
+		if err != nil {
+			return err
+		}
+		if saved {
+			report.Repaired = append(report.Repaired, key)
+		}
+	}
+
+	for key := range backups {
+		if timeline[key] {
+			continue
+		}
+		e, ok := entries[key]
+		if ok && e.Deleted {
+			deleted, err := r.deleteRemoved(ctx, e)
+			if err != nil {
+				return err
+			}
+			if deleted {
+				report.Repaired = append(report.Repaired, key)
+				continue
+			}
+		}
+		if _, ok := namespaces[namespaceOfKey(key)]; ok {
+			report.OrphanedBackup = append(report.OrphanedBackup, key)
+		}
+	}
+
+	for key, e := range entries {
+		if !e.Deleted && !timeline[key] && !backups[key] {
+			if _, ok := namespaces[e.Namespace]; ok {
+				report.LocalOnly = append(report.LocalOnly, key)
+			}
+		}
+	}
+	return nil
+}
+
+// saveLive saves the backup of an entry found in the timeline without
+// backup. Remove deletes the event before the backup, so if the event is
+// gone once the backup is saved, the entry is being removed and the backup
+// is deleted again, unless the entry was added back since.
+func (r *reconciler) saveLive(ctx context.Context, n *Namespace, e Entry) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.agent.custom.timeout)
+	defer cancel()
+
+	backup := r.agent.storage.backup
+	if err := backup.Save(ctx, &e); err != nil {
+		return false, err
+	}
+
+	event, err := n.cron.timeline.Find(ctx, e.Name)
+	if err != nil || !event.IsEmpty() {
+		return err == nil, err
+	}
+
+	saved, err := backup.Load(ctx, e.Key())
+	if errors.Is(err, ErrEntryNotFound) {
+		return false, nil
+	}
+	if err != nil || saved.Version != e.Version {
+		return false, err
+	}
+	return false, backup.Delete(ctx, e.Key())
+}
+
+// deleteRemoved deletes the backup of a removed entry, unless the backup is
+// newer than the removal, as the entry is added again.
+func (r *reconciler) deleteRemoved(ctx context.Context, tombstone Entry) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.agent.custom.timeout)
+	defer cancel()
+
+	backup := r.agent.storage.backup
+	e, err := backup.Load(ctx, tombstone.Key())
+	if errors.Is(err, ErrEntryNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !e.Version.Less(tombstone.Version) {
+		return false, nil
+	}
+	return true, backup.Delete(ctx, tombstone.Key())
+}
+
+// namespaceOfKey is the namespace of an entry key, see entryKey
+func namespaceOfKey(key string) string {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return DefaultNamespace
+}
@@ -0,0 +1,132 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReconcile(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		a        = newTestAgent(t)
+		n        = a.defaultNamespace()
+		backup   = a.storage.backup
+		timeline = n.cron.timeline
+	)
+
+	add := func(name string, inTimeline, inBackup, inMemory bool) *Entry {
+		e := &Entry{Namespace: DefaultNamespace, Name: name, Spec: testSpec, Version: a.entries.NewVersion()}
+		if inTimeline {
+			if err := timeline.Add(ctx, Event{Name: name, Time: time.Now(), Displayed: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if inBackup {
+			if err := backup.Save(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if inMemory {
+			a.entries.Add(e)
+		}
+		return e
+	}
+
+	add("ok", true, true, true)
+	add("unsaved", true, false, true)
+	add("orphaned-timeline", true, false, false)
+	add("orphaned-backup", false, true, false)
+	add("local-only", false, false, true)
+	removed := add("removed", false, true, true)
+	a.entries.Remove(removed.Key(), a.entries.NewVersion())
+
+	report := a.Reconcile()
+	if report.Err != "" {
+		t.Fatal(report.Err)
+	}
+	for _, c := range []struct {
+		name       string
+		keys, want []string
+	}{
+		{"orphaned timeline", report.OrphanedTimeline, []string{"orphaned-timeline"}},
+		{"orphaned backup", report.OrphanedBackup, []string{"orphaned-backup"}},
+		{"local only", report.LocalOnly, []string{"local-only"}},
+		{"repaired", report.Repaired, []string{"removed", "unsaved"}},
+	} {
+		if fmt.Sprint(c.keys) != fmt.Sprint(c.want) {
+			t.Errorf("%s %v, want %v", c.name, c.keys, c.want)
+		}
+	}
+	if _, err := backup.Load(ctx, "unsaved"); err != nil {
+		t.Errorf("backup not saved: %v", err)
+	}
+	if _, err := backup.Load(ctx, "removed"); err != ErrEntryNotFound {
+		t.Errorf("backup of the removed entry: %v", err)
+	}
+
+	// once repaired, only the orphans are left
+	report = a.Reconcile()
+	if len(report.Repaired) != 0 || len(report.OrphanedTimeline) != 1 || len(report.OrphanedBackup) != 1 {
+		t.Fatalf("report %+v after repair", report)
+	}
+}
+
+func TestReconcileSaveRemoved(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		a      = newTestAgent(t)
+		n      = a.defaultNamespace()
+		backup = a.storage.backup
+	)
+
+	// the entry is removed from the timeline and the backup after they are
+	// read, but not yet from memory
+	e := Entry{Namespace: DefaultNamespace, Name: "job", Spec: testSpec, Version: a.entries.NewVersion()}
+	saved, err := a.reconciler.saveLive(ctx, n, e)
+	if err != nil || saved {
+		t.Fatalf("saved %v, %v, want the backup of a removed entry left out", saved, err)
+	}
+	if _, err := backup.Load(ctx, e.Key()); err != ErrEntryNotFound {
+		t.Fatalf("backup of a removed entry: %v", err)
+	}
+
+	// still in the timeline, the backup is saved
+	if err := n.cron.timeline.Add(ctx, Event{Name: "job", Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := a.reconciler.saveLive(ctx, n, e); err != nil || !saved {
+		t.Fatalf("saved %v, %v, want the backup saved", saved, err)
+	}
+}
+
+func TestExpiredWithoutEntry(t *testing.T) {
+	var (
+		ctx = context.Background()
+		a   = newTestAgent(t)
+		c   = a.defaultNamespace().cron
+		at  = time.Now().Add(-time.Minute).Truncate(time.Second)
+	)
+
+	for _, name := range []string{"missing", "removed"} {
+		if err := c.timeline.Add(ctx, Event{Name: name, Time: at, Displayed: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.entries.Remove("removed", a.entries.NewVersion())
+
+	// left in the timeline, the run loop backs off
+	_, skip, err := c.doExpired(time.Now(), Event{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !skip {
+		t.Fatal("expired events without entry not skipped")
+	}
+	for _, name := range []string{"missing", "removed"} {
+		if e, err := c.timeline.Find(ctx, name); err != nil || !e.Time.Equal(at) {
+			t.Fatalf("event %+v, %v, want it left at %s", e, err, at)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package cron
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The sql backends only use portable sql understood by both SQLite and
+// PostgreSQL ("$n" placeholders, "ON CONFLICT" upsert). The database driver
+// should be registered by the application, for example:
+//
+//	import _ "github.com/mattn/go-sqlite3"
+//	import _ "github.com/lib/pq"
+
+type sqlTimeline struct {
+	db    *sql.DB
+	table string
+}
+
+func NewSQLTimeline(db *sql.DB, table string) Timeline {
+	t := &sqlTimeline{
+		db:    db,
+		table: quoteIdent(table),
+	}
+
+	t.mustExec(`CREATE TABLE IF NOT EXISTS %s (
+	name      VARCHAR(255) PRIMARY KEY,
+	ts        BIGINT       NOT NULL,
+	displayed BOOLEAN      NOT NULL
+)`)
+	return t
+}
+
+func (s *sqlTimeline) Add(ctx context.Context, event Event) error {
+	_, err := s.db.ExecContext(ctx, s.query(`INSERT INTO %s (name, ts, displayed) VALUES ($1, $2, $3)
+ON CONFLICT (name) DO UPDATE SET ts = excluded.ts, displayed = excluded.displayed`),
+		event.Name, event.Time.Unix(), event.Displayed)
+	return err
+}
+
+func (s *sqlTimeline) Remove(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, s.query(`DELETE FROM %s WHERE name = $1`), name)
+	return err
+}
+
+func (s *sqlTimeline) Hide(ctx context.Context, name string) error {
+	return s.setDisplayed(ctx, name, false)
+}
+
+func (s *sqlTimeline) Display(ctx context.Context, name string) error {
+	return s.setDisplayed(ctx, name, true)
+}
+
+func (s *sqlTimeline) setDisplayed(ctx context.Context, name string, displayed bool) error {
+	res, err := s.db.ExecContext(ctx, s.query(`UPDATE %s SET displayed = $1 WHERE name = $2`), displayed, name)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEventNotFound
+	}
+	return nil
+}
+
+func (s *sqlTimeline) TryModify(ctx context.Context, event Event, t time.Time) (bool, error) {
+	return s.modify(ctx, s.db, event, t)
+}
+
+func (s *sqlTimeline) TryModifyBatch(ctx context.Context, claims []Claim) ([]Event, error) {
+	if len(claims) == 0 {
+		return nil, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var events []Event
+	for _, c := range claims {
+		ok, err := s.modify(ctx, tx, c.Event, c.Next)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			events = append(events, c.Event)
+		}
+	}
+	return events, tx.Commit()
+}
+
+// modify is a row level CAS on the time and the displayed state of event
+func (s *sqlTimeline) modify(ctx context.Context, db execer, event Event, t time.Time) (bool, error) {
+	res, err := db.ExecContext(ctx, s.query(`UPDATE %s SET ts = $1
+WHERE name = $2 AND ts = $3 AND displayed = $4`),
+		t.Unix(), event.Name, event.Time.Unix(), event.Displayed)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *sqlTimeline) Find(ctx context.Context, name string) (Event, error) {
+	var (
+		ts    int64
+		event = Event{Name: name}
+	)
+
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT ts, displayed FROM %s WHERE name = $1`), name).
+		Scan(&ts, &event.Displayed)
+	if err == sql.ErrNoRows {
+		return Event{}, nil
+	}
+	if err != nil {
+		return Event{}, err
+	}
+
+	event.Time = time.Unix(ts, 0)
+	return event, nil
+}
+
+func (s *sqlTimeline) FindEarliest(ctx context.Context) (Event, error) {
+	events, err := s.events(ctx, `SELECT name, ts, displayed FROM %s
+WHERE displayed = $1 ORDER BY ts, name LIMIT 1`, true)
+	if err != nil || len(events) == 0 {
+		return Event{}, err
+	}
+	return events[0], nil
+}
+
+func (s *sqlTimeline) FetchHistory(ctx context.Context, t time.Time, after Event, limit int64) ([]Event, error) {
+	query := `SELECT name, ts, displayed FROM %s
+WHERE displayed = $1 AND ts <= $2 AND (ts > $3 OR (ts = $3 AND name > $4))
+ORDER BY ts, name`
+	args := []interface{}{true, t.Unix(), after.Time.Unix(), after.Name}
+	if limit > 0 {
+		query += ` LIMIT $5`
+		args = append(args, limit)
+	}
+
+	// limited for displayed events
+	return s.events(ctx, query, args...)
+}
+
+func (s *sqlTimeline) Events(ctx context.Context) ([]Event, error) {
+	return s.events(ctx, `SELECT name, ts, displayed FROM %s ORDER BY ts, name`)
+}
+
+func (s *sqlTimeline) Close() {}
+
+func (s *sqlTimeline) events(ctx context.Context, query string, args ...interface{}) ([]Event, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events = make([]Event, 0)
+	for rows.Next() {
+		var (
+			ts    int64
+			event Event
+		)
+		if err := rows.Scan(&event.Name, &ts, &event.Displayed); err != nil {
+			return nil, err
+		}
+		event.Time = time.Unix(ts, 0)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *sqlTimeline) query(q string) string { return fmt.Sprintf(q, s.table) }
+
+func (s *sqlTimeline) mustExec(q string) {
+	if _, err := s.db.Exec(s.query(q)); err != nil {
+		Logger.Fatalln(err)
+	}
+}
+
+type sqlEntryBackup struct {
+	db    *sql.DB
+	table string
+}
+
+func NewSQLEntryBackup(db *sql.DB, table string) EntryBackup {
+	b := &sqlEntryBackup{
+		db:    db,
+		table: quoteIdent(table),
+	}
+
+	if _, err := db.Exec(b.query(`CREATE TABLE IF NOT EXISTS %s (
+	name VARCHAR(255) PRIMARY KEY,
+	data TEXT         NOT NULL
+)`)); err != nil {
+		Logger.Fatalln(err)
+	}
+	return b
+}
+
+func (s *sqlEntryBackup) Save(ctx context.Context, e *Entry) error {
+	ser, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.query(`INSERT INTO %s (name, data) VALUES ($1, $2)
+ON CONFLICT (name) DO UPDATE SET data = excluded.data`), e.Key(), string(ser))
+	return err
+}
+
+func (s *sqlEntryBackup) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.query(`DELETE FROM %s WHERE name = $1`), key)
+	return err
+}
+
+func (s *sqlEntryBackup) Load(ctx context.Context, key string) (*Entry, error) {
+	var ser string
+
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT data FROM %s WHERE name = $1`), key).Scan(&ser)
+	if err == sql.ErrNoRows {
+		return nil, ErrEntryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry([]byte(ser))
+}
+
+func (s *sqlEntryBackup) Keys(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.query(`SELECT name FROM %s`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+type sqlExecutionStore struct {
+	db    *sql.DB
+	table string
+
+	maxHistoryNum int64
+}
+
+func NewSQLExecutionStore(db *sql.DB, table string, maxHistoryNum int64) ExecutionStore {
+	s := &sqlExecutionStore{
+		db:            db,
+		table:         quoteIdent(table),
+		maxHistoryNum: maxHistoryNum,
+	}
+
+	for _, q := range []string{
+		s.query(`CREATE TABLE IF NOT EXISTS %s (
+	id         VARCHAR(36)  PRIMARY KEY,
+	name       VARCHAR(255) NOT NULL,
+	started_at BIGINT       NOT NULL,
+	running    BOOLEAN      NOT NULL,
+	data       TEXT         NOT NULL
+)`),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (name, started_at)`,
+			quoteIdent(table+"_name_idx"), s.table),
+	} {
+		if _, err := db.Exec(q); err != nil {
+			Logger.Fatalln(err)
+		}
+	}
+	return s
+}
+
+func (s *sqlExecutionStore) Begin(ctx context.Context, e *Execution) error {
+	ser, _ := json.Marshal(e)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, s.query(`INSERT INTO %s (id, name, started_at, running, data)
+VALUES ($1, $2, $3, $4, $5)`),
+		e.ID.String(), e.Name, e.StartedAt, true, string(ser)); err != nil {
+		return err
+	}
+
+	// keep the latest maxHistoryNum executions of the job
+	if _, err = tx.ExecContext(ctx, s.query(`DELETE FROM %[1]s WHERE name = $1 AND running = $2 AND id NOT IN (
+	SELECT id FROM %[1]s WHERE name = $1 ORDER BY started_at DESC, id DESC LIMIT $3
+)`), e.Name, false, s.maxHistoryNum); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlExecutionStore) Finish(ctx context.Context, e *Execution) error {
+	ser, _ := json.Marshal(e)
+
+	_, err := s.db.ExecContext(ctx, s.query(`UPDATE %s SET running = $1, data = $2 WHERE id = $3`),
+		false, string(ser), e.ID.String())
+	return err
+}
+
+func (s *sqlExecutionStore) Running(ctx context.Context) ([]Execution, error) {
+	return s.executions(ctx, s.query(`SELECT data FROM %s WHERE running = $1`), true)
+}
+
+func (s *sqlExecutionStore) History(ctx context.Context, jobName string, offset, size int64) ([]Execution, error) {
+	if size <= 0 {
+		return []Execution{}, nil
+	}
+	return s.executions(ctx, s.query(`SELECT data FROM %s WHERE name = $1
+ORDER BY started_at DESC, id DESC LIMIT $2 OFFSET $3`), jobName, size, offset)
+}
+
+func (s *sqlExecutionStore) executions(ctx context.Context, query string, args ...interface{}) ([]Execution, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var executions = make([]Execution, 0)
+	for rows.Next() {
+		var ser string
+		if err := rows.Scan(&ser); err != nil {
+			return nil, err
+		}
+
+		var execution Execution
+		if err := json.Unmarshal([]byte(ser), &execution); err != nil {
+			Logger.Warn("fetchExecutions err", ser)
+			continue
+		}
+		executions = append(executions, execution)
+	}
+	return executions, rows.Err()
+}
+
+func (s *sqlEntryBackup) query(q string) string { return fmt.Sprintf(q, s.table) }
+
+func (s *sqlExecutionStore) query(q string) string { return fmt.Sprintf(q, s.table) }
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,41 @@
+package cron_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yinyajun/cron"
+	"github.com/yinyajun/cron/timelinetest"
+)
+
+func TestSQLTimelineFile(t *testing.T) {
+	timelinetest.Run(t, func(t *testing.T) cron.Timeline {
+		db := openSQLite(t, filepath.Join(t.TempDir(), "cron.db"))
+		return cron.NewSQLTimeline(db, "_timeline")
+	})
+}
+
+func TestSQLEntryBackup(t *testing.T) {
+	testEntryBackup(t, cron.NewSQLEntryBackup(openSQLite(t, ":memory:"), "_entry"))
+}
+
+func TestSQLExecutionStore(t *testing.T) {
+	testExecutionStore(t, cron.NewSQLExecutionStore(openSQLite(t, ":memory:"), "_execution", 3))
+}
+
+func TestSQLTimelineCloseShared(t *testing.T) {
+	var (
+		ctx = context.Background()
+		db  = openSQLite(t, ":memory:")
+		a   = cron.NewSQLTimeline(db, "_timeline")
+		b   = cron.NewSQLTimeline(db, "_timeline:b")
+	)
+
+	// the timelines of the namespaces share the database
+	a.Close()
+	if err := b.Add(ctx, cron.Event{Name: "job", Time: time.Now(), Displayed: true}); err != nil {
+		t.Fatalf("add after closing another timeline: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package cron_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"github.com/yinyajun/cron"
+)
+
+// testEntryBackup checks the round trips of an empty entry backup
+func testEntryBackup(t *testing.T, backup cron.EntryBackup) {
+	ctx := context.Background()
+
+	if err := backup.Save(ctx, &cron.Entry{Name: "a", Spec: "* * * * * *"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.Save(ctx, &cron.Entry{Name: "a", Spec: "*/2 * * * * *"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.Save(ctx, &cron.Entry{Name: "b", Namespace: "team", Spec: "* * * * * *"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := backup.Load(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Spec != "*/2 * * * * *" {
+		t.Fatalf("spec %q, want the last saved", e.Spec)
+	}
+
+	keys, err := backup.Keys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "team/b" {
+		t.Fatalf("keys %v, want a and team/b", keys)
+	}
+
+	if err := backup.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.Load(ctx, "a"); !errors.Is(err, cron.ErrEntryNotFound) {
+		t.Fatalf("load deleted entry: %v", err)
+	}
+}
+
+// testExecutionStore checks an empty execution store keeping the latest 3
+// executions of a job
+func testExecutionStore(t *testing.T, store cron.ExecutionStore) {
+	ctx := context.Background()
+
+	// 5 executions, the last one still running
+	for i := int64(0); i < 5; i++ {
+		e := &cron.Execution{ID: uuid.New(), Name: "job", StartedAt: i}
+		if err := store.Begin(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		if i < 4 {
+			e.FinishedAt = i + 1
+			if err := store.Finish(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	running, err := store.Running(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || running[0].StartedAt != 4 {
+		t.Fatalf("running %v, want the last execution", running)
+	}
+
+	// Begin trims the history to the latest 3 executions
+	history, err := store.History(ctx, "job", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("history of %d executions, want 3", len(history))
+	}
+	for i, e := range history {
+		if e.StartedAt != int64(4-i) {
+			t.Fatalf("history[%d] started at %d, want %d", i, e.StartedAt, 4-i)
+		}
+	}
+
+	history, err = store.History(ctx, "job", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].StartedAt != 3 {
+		t.Fatalf("history page %v, want started at 3 and 2", history)
+	}
+}
+
+func TestRedisEntryBackup(t *testing.T) {
+	s := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { cli.Close() })
+
+	testEntryBackup(t, cron.NewRedisEntryBackup(cli, "_entry"))
+}
@@ -0,0 +1,212 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var ErrStreamDisabled = errors.New("change stream disabled")
+
+type ChangeType string
+
+const (
+	ChangeAdd     ChangeType = "add"
+	ChangeRemove  ChangeType = "remove"
+	ChangeHide    ChangeType = "hide"
+	ChangeDisplay ChangeType = "display"
+	ChangeClaim   ChangeType = "claim"
+)
+
+// Change is a mutation of the timeline
+type Change struct {
+	ID   string     `json:"id"` // stream id, assigned by redis
+	Type ChangeType `json:"type"`
+	Name string     `json:"name"`
+	Time int64      `json:"time,omitempty"` // next time of added or claimed event
+	Node string     `json:"node,omitempty"` // claiming node
+}
+
+// ChangeStream is a capped redis stream of the changes of one timeline, so
+// downstream systems can tail the schedule changes and dispatches.
+type ChangeStream struct {
+	cli    redis.UniversalClient
+	key    string
+	maxLen int64
+}
+
+// NewChangeStream creates the stream at key, which keeps about maxLen
+// latest changes.
+func NewChangeStream(cli redis.UniversalClient, key string, maxLen int64) *ChangeStream {
+	return &ChangeStream{
+		cli:    cli,
+		key:    key,
+		maxLen: maxLen,
+	}
+}
+
+func (s *ChangeStream) Append(ctx context.Context, changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, c := range changes {
+			values := []interface{}{"type", string(c.Type), "name", c.Name}
+			if c.Time != 0 {
+				values = append(values, "time", c.Time)
+			}
+			if c.Node != "" {
+				values = append(values, "node", c.Node)
+			}
+
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: s.key,
+				MaxLen: s.maxLen,
+				Approx: true,
+				Values: values,
+			})
+		}
+		return nil
+	})
+	return err
+}
+
+// Read reads at most count changes after id, an empty id reads from the
+// oldest change. If there is no change, Read waits for block at most, block
+// <= 0 means no waiting.
+func (s *ChangeStream) Read(ctx context.Context, id string, count int64, block time.Duration) ([]Change, error) {
+	if id == "" {
+		id = "0-0"
+	}
+	if block <= 0 {
+		block = -1
+	}
+
+	streams, err := s.cli.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{s.key, id},
+		Count:   count,
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return []Change{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var changes = make([]Change, 0)
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			changes = append(changes, parseChange(msg))
+		}
+	}
+	return changes, nil
+}
+
+func parseChange(msg redis.XMessage) Change {
+	c := Change{ID: msg.ID}
+	c.Type = ChangeType(stringValue(msg.Values["type"]))
+	c.Name = stringValue(msg.Values["name"])
+	c.Time, _ = strconv.ParseInt(stringValue(msg.Values["time"]), 10, 64)
+	c.Node = stringValue(msg.Values["node"])
+	return c
+}
+
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// streamTimeline appends every successful mutation of the timeline to a
+// change stream. The stream is best effort: a failed append is logged and
+// does not fail the mutation, which is already done.
+type streamTimeline struct {
+	Timeline
+
+	stream *ChangeStream
+	node   string
+}
+
+// NewStreamTimeline wraps t, so its mutations are appended to stream, and
+// the claims are attributed to node.
+func NewStreamTimeline(t Timeline, stream *ChangeStream, node string) Timeline {
+	return &streamTimeline{
+		Timeline: t,
+		stream:   stream,
+		node:     node,
+	}
+}
+
+func (s *streamTimeline) Add(ctx context.Context, event Event) error {
+	if err := s.Timeline.Add(ctx, event); err != nil {
+		return err
+	}
+	s.append(ctx, Change{Type: ChangeAdd, Name: event.Name, Time: event.Time.Unix()})
+	return nil
+}
+
+func (s *streamTimeline) Remove(ctx context.Context, name string) error {
+	if err := s.Timeline.Remove(ctx, name); err != nil {
+		return err
+	}
+	s.append(ctx, Change{Type: ChangeRemove, Name: name})
+	return nil
+}
+
+func (s *streamTimeline) Hide(ctx context.Context, name string) error {
+	if err := s.Timeline.Hide(ctx, name); err != nil {
+		return err
+	}
+	s.append(ctx, Change{Type: ChangeHide, Name: name})
+	return nil
+}
+
+func (s *streamTimeline) Display(ctx context.Context, name string) error {
+	if err := s.Timeline.Display(ctx, name); err != nil {
+		return err
+	}
+	s.append(ctx, Change{Type: ChangeDisplay, Name: name})
+	return nil
+}
+
+func (s *streamTimeline) TryModify(ctx context.Context, event Event, t time.Time) (bool, error) {
+	ok, err := s.Timeline.TryModify(ctx, event, t)
+	if err != nil || !ok {
+		return ok, err
+	}
+	s.append(ctx, s.claim(event.Name, t))
+	return true, nil
+}
+
+func (s *streamTimeline) TryModifyBatch(ctx context.Context, claims []Claim) ([]Event, error) {
+	events, err := s.Timeline.TryModifyBatch(ctx, claims)
+	if err != nil || len(events) == 0 {
+		return events, err
+	}
+
+	next := make(map[string]time.Time, len(claims))
+	for _, c := range claims {
+		next[c.Event.Name] = c.Next
+	}
+
+	changes := make([]Change, len(events))
+	for i, event := range events {
+		changes[i] = s.claim(event.Name, next[event.Name])
+	}
+	s.append(ctx, changes...)
+	return events, nil
+}
+
+func (s *streamTimeline) claim(name string, next time.Time) Change {
+	return Change{Type: ChangeClaim, Name: name, Time: next.Unix(), Node: s.node}
+}
+
+func (s *streamTimeline) append(ctx context.Context, changes ...Change) {
+	if err := s.stream.Append(ctx, changes...); err != nil {
+		Logger.Warn("append change stream failed: ", err.Error())
+	}
+}
@@ -0,0 +1,102 @@
+package cron_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/yinyajun/cron"
+)
+
+func newTestChangeStream(t *testing.T) *cron.ChangeStream {
+	s := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { cli.Close() })
+	return cron.NewChangeStream(cli, "_stream", 100)
+}
+
+func TestStreamTimeline(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		stream = newTestChangeStream(t)
+		tl     = cron.NewStreamTimeline(cron.NewMemoryTimeline(), stream, "n1")
+		now    = time.Unix(time.Now().Unix(), 0)
+	)
+
+	changes, err := stream.Read(ctx, "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("%d changes in an empty stream", len(changes))
+	}
+
+	a := cron.Event{Name: "a", Time: now, Displayed: true}
+	b := cron.Event{Name: "b", Time: now, Displayed: true}
+	for _, e := range []cron.Event{a, b} {
+		if err := tl.Add(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tl.Hide(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.Display(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tl.TryModify(ctx, a, now.Add(time.Minute)); err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if events, err := tl.TryModifyBatch(ctx, []cron.Claim{{Event: b, Next: now.Add(time.Hour)}}); err != nil || len(events) != 1 {
+		t.Fatal(events, err)
+	}
+	if err := tl.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// failed mutations and lost claims are not appended
+	if err := tl.Hide(ctx, "missing"); err == nil {
+		t.Fatal("hide of a missing event succeeded")
+	}
+	if ok, _ := tl.TryModify(ctx, b, now.Add(time.Hour)); ok {
+		t.Fatal("claim of a stale event succeeded")
+	}
+
+	want := []cron.Change{
+		{Type: cron.ChangeAdd, Name: "a", Time: now.Unix()},
+		{Type: cron.ChangeAdd, Name: "b", Time: now.Unix()},
+		{Type: cron.ChangeHide, Name: "b"},
+		{Type: cron.ChangeDisplay, Name: "b"},
+		{Type: cron.ChangeClaim, Name: "a", Time: now.Add(time.Minute).Unix(), Node: "n1"},
+		{Type: cron.ChangeClaim, Name: "b", Time: now.Add(time.Hour).Unix(), Node: "n1"},
+		{Type: cron.ChangeRemove, Name: "a"},
+	}
+	changes, err = stream.Read(ctx, "", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("%d changes, want %d: %v", len(changes), len(want), changes)
+	}
+	for i, c := range changes {
+		if c.ID == "" {
+			t.Fatalf("change %d without id", i)
+		}
+		c.ID = ""
+		if c != want[i] {
+			t.Fatalf("change %d: %+v, want %+v", i, c, want[i])
+		}
+	}
+
+	// reading after a change resumes from the next one
+	after, err := stream.Read(ctx, changes[4].ID, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 1 || after[0].ID != changes[5].ID {
+		t.Fatalf("changes after %s: %v, want %s", changes[4].ID, after, changes[5].ID)
+	}
+}
@@ -2,13 +2,17 @@ package cron
 
 import (
 	"context"
-	"reflect"
+	"encoding/json"
+	"errors"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+var ErrEventNotFound = errors.New("event not found")
+
 type Event struct {
 	Name      string
 	Time      time.Time
@@ -17,146 +21,292 @@ type Event struct {
 
 func (e Event) IsEmpty() bool { return e.Name == "" }
 
+// Claim proposes to change the time of Event to Next
+type Claim struct {
+	Event Event
+	Next  time.Time
+}
+
 type Timeline interface {
 	// Add adds a new event to timeline
-	Add(e Event) error
+	Add(ctx context.Context, e Event) error
 	// Remove removes an event to timeline
-	Remove(name string) error
+	Remove(ctx context.Context, name string) error
 	// Hide hides the event
-	Hide(name string) error
+	Hide(ctx context.Context, name string) error
 	// Display displays the event
-	Display(name string) error
+	Display(ctx context.Context, name string) error
 
 	// TryModify tries to change the event time to t (CAS operation)
-	TryModify(e Event, t time.Time) (bool, error)
+	TryModify(ctx context.Context, e Event, t time.Time) (bool, error)
+	// TryModifyBatch applies TryModify to all the claims at once,
+	// returns the events successfully modified in the order of claims
+	TryModifyBatch(ctx context.Context, claims []Claim) ([]Event, error)
 
 	// Find the event
-	Find(name string) (Event, error)
+	Find(ctx context.Context, name string) (Event, error)
 	// FindEarliest finds the earliest displayed event
-	FindEarliest() (Event, error)
-	// FetchHistory fetches displayed events happens <= t
-	FetchHistory(t time.Time) ([]Event, error)
-	// Events  including hidden events
-	Events() ([]Event, error)
-
+	FindEarliest(ctx context.Context) (Event, error)
+	// FetchHistory fetches displayed events happens <= t, ordered by time
+	// and name. Only events after the cursor are fetched (an empty cursor
+	// starts from the beginning), at most limit events if limit > 0
+	FetchHistory(ctx context.Context, t time.Time, after Event, limit int64) ([]Event, error)
+	// Events  including hidden events, ordered by time and name
+	Events(ctx context.Context) ([]Event, error)
+
+	// Close releases the resources of the timeline. The client or database
+	// given to the constructor is shared by the timelines of all the
+	// namespaces, it is left open for its owner to close.
 	Close()
 }
 
-type redisTimeline struct {
-	key string
-	cli *redis.Client
+// sortEvents sorts events by time, then by name.
+func sortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool { return eventAfter(events[j], events[i]) })
 }
 
-func NewRedisTimeline(cli *redis.Client, key string) Timeline {
-	return &redisTimeline{
-		cli: cli,
-		key: key,
+// eventAfter reports whether e is after cursor in (time, name) order
+func eventAfter(e, cursor Event) bool {
+	if e.Time.Unix() != cursor.Time.Unix() {
+		return e.Time.Unix() > cursor.Time.Unix()
 	}
+	return e.Name > cursor.Name
 }
 
-func (r redisTimeline) Remove(name string) error {
-	return r.cli.ZRem(context.Background(), r.key, name).Err()
+// redisTimeline keeps the state of every event in a hash, and the schedule
+// of displayed events in a sorted set scored by time:
+//
+//	key       -> sorted set, name -> unix time of displayed events
+//	key_state -> hash, name -> json of eventState
+type redisTimeline struct {
+	key      string
+	stateKey string
+	cli      redis.UniversalClient
 }
 
-func (r redisTimeline) Add(event Event) error {
-	return r.cli.ZAdd(context.Background(), r.key, &redis.Z{
-		Score:  float64(r.time2ts(event.Time, event.Displayed)),
-		Member: event.Name,
-	}).Err()
+// eventState is the state of an event in redisTimeline, more fields can be
+// added without changing the schedule.
+type eventState struct {
+	Time      int64 `json:"t"`
+	Displayed bool  `json:"d"`
+	LastRun   int64 `json:"last_run,omitempty"`
 }
 
-func (r redisTimeline) Hide(name string) error {
-	cmd := r.cli.ZScore(context.Background(), r.key, name)
-	if cmd.Err() != nil {
-		return cmd.Err()
+func NewRedisTimeline(cli redis.UniversalClient, key string) Timeline {
+	r := &redisTimeline{
+		cli:      cli,
+		key:      key,
+		stateKey: key + "_state",
 	}
 
-	event := Event{Name: name}
-	event.Time, event.Displayed = r.ts2time(int64(cmd.Val()))
-
-	if !event.Displayed {
-		return nil
+	if n, err := r.migrate(); err != nil {
+		Logger.Error("timeline migrate failed: ", err.Error())
+	} else if n > 0 {
+		Logger.Infof("timeline migrate %d events", n)
 	}
 
-	event.Displayed = false
-	return r.Add(event)
+	return r
 }
 
-func (r redisTimeline) Display(name string) error {
-	cmd := r.cli.ZScore(context.Background(), r.key, name)
-	if cmd.Err() != nil {
-		return cmd.Err()
-	}
+// Input:
+// KEYS[1] -> key
+// KEYS[2] -> state key
+//
+// Output:
+// Returns the number of migrated events
+//
+// Before the state hash exists, the state of an event was encoded in its
+// score: positive time for displayed events, negative time for hidden ones.
+var migrateCmd = redis.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	return 0
+end
+local members = redis.call("ZRANGE", KEYS[1], 0, -1, "WITHSCORES")
+for i = 1, #members, 2 do
+	local score = tonumber(members[i+1])
+	local state = {t = math.abs(score), d = score > 0}
+	redis.call("HSET", KEYS[2], members[i], cjson.encode(state))
+	if not state.d then
+		redis.call("ZREM", KEYS[1], members[i])
+	end
+end
+return #members / 2
+`)
+
+func (r *redisTimeline) migrate() (int64, error) {
+	return migrateCmd.Run(context.Background(), r.cli, []string{r.key, r.stateKey}).Int64()
+}
 
-	event := Event{Name: name}
-	event.Time, event.Displayed = r.ts2time(int64(cmd.Val()))
+func (r *redisTimeline) Remove(ctx context.Context, name string) error {
+	_, err := r.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, r.key, name)
+		pipe.HDel(ctx, r.stateKey, name)
+		return nil
+	})
+	return err
+}
 
-	if event.Displayed {
+func (r *redisTimeline) Add(ctx context.Context, event Event) error {
+	ser, _ := json.Marshal(eventState{
+		Time:      event.Time.Unix(),
+		Displayed: event.Displayed,
+	})
+
+	_, err := r.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.stateKey, event.Name, ser)
+		if event.Displayed {
+			pipe.ZAdd(ctx, r.key, &redis.Z{
+				Score:  float64(event.Time.Unix()),
+				Member: event.Name,
+			})
+		} else {
+			pipe.ZRem(ctx, r.key, event.Name)
+		}
 		return nil
-	}
+	})
+	return err
+}
+
+func (r *redisTimeline) Hide(ctx context.Context, name string) error {
+	return r.setDisplayed(ctx, name, false)
+}
 
-	event.Displayed = true
-	return r.Add(event)
+func (r *redisTimeline) Display(ctx context.Context, name string) error {
+	return r.setDisplayed(ctx, name, true)
 }
 
 // Input:
 // KEYS[1] -> key
+// KEYS[2] -> state key
 // --
 // ARGV[1] -> event.Name
-// ARGV[2] -> event.Time
-// ARGV[3] -> t
+// ARGV[2] -> displayed ("1" or "0")
 //
 // Output:
-// Returns 1 if successfully modified
-// Returns 0 if entry already modified
-var modifyCmd = redis.NewScript(`
-if redis.call("ZSCORE", KEYS[1], ARGV[1]) ~= ARGV[2] then 
+// Returns 1 if the event exists
+// Returns 0 if the event not found
+var displayCmd = redis.NewScript(`
+local old = redis.call("HGET", KEYS[2], ARGV[1])
+if not old then
 	return 0
 end
-redis.call("ZADD" , KEYS[1], ARGV[3], ARGV[1])
+local state = cjson.decode(old)
+state.d = ARGV[2] == "1"
+redis.call("HSET", KEYS[2], ARGV[1], cjson.encode(state))
+if state.d then
+	redis.call("ZADD", KEYS[1], state.t, ARGV[1])
+else
+	redis.call("ZREM", KEYS[1], ARGV[1])
+end
 return 1
 `)
 
-func (r redisTimeline) TryModify(event Event, t time.Time) (bool, error) {
+func (r *redisTimeline) setDisplayed(ctx context.Context, name string, displayed bool) error {
 	keys := []string{
 		r.key,
+		r.stateKey,
 	}
 	argv := []interface{}{
-		event.Name,
-		r.time2ts(event.Time, event.Displayed),
-		r.time2ts(t, event.Displayed),
+		name,
+		boolArg(displayed),
+	}
+	n, err := displayCmd.Run(ctx, r.cli, keys, argv...).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEventNotFound
+	}
+	return nil
+}
+
+// Input:
+// KEYS[1] -> key
+// KEYS[2] -> state key
+// --
+// ARGV[4i+1] -> event.Name
+// ARGV[4i+2] -> event.Time
+// ARGV[4i+3] -> event.Displayed ("1" or "0")
+// ARGV[4i+4] -> t
+//
+// Output:
+// Returns the names of events successfully modified
+var modifyCmd = redis.NewScript(`
+local modified = {}
+for i = 1, #ARGV, 4 do
+	local old = redis.call("HGET", KEYS[2], ARGV[i])
+	if old then
+		local state = cjson.decode(old)
+		if state.t == tonumber(ARGV[i+1]) and state.d == (ARGV[i+2] == "1") then
+			state.last_run = state.t
+			state.t = tonumber(ARGV[i+3])
+			redis.call("HSET", KEYS[2], ARGV[i], cjson.encode(state))
+			if state.d then
+				redis.call("ZADD", KEYS[1], ARGV[i+3], ARGV[i])
+			end
+			modified[#modified+1] = ARGV[i]
+		end
+	end
+end
+return modified
+`)
+
+func (r *redisTimeline) TryModify(ctx context.Context, event Event, t time.Time) (bool, error) {
+	events, err := r.TryModifyBatch(ctx, []Claim{{Event: event, Next: t}})
+	return len(events) == 1, err
+}
+
+func (r *redisTimeline) TryModifyBatch(ctx context.Context, claims []Claim) ([]Event, error) {
+	if len(claims) == 0 {
+		return nil, nil
+	}
+
+	keys := []string{
+		r.key,
+		r.stateKey,
+	}
+	argv := make([]interface{}, 0, 4*len(claims))
+	for _, c := range claims {
+		argv = append(argv,
+			c.Event.Name,
+			c.Event.Time.Unix(),
+			boolArg(c.Event.Displayed),
+			c.Next.Unix(),
+		)
 	}
-	res, err := modifyCmd.Run(context.Background(), r.cli, keys, argv...).Result()
+	names, err := modifyCmd.Run(ctx, r.cli, keys, argv...).StringSlice()
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	modified := make(map[string]bool, len(names))
+	for _, name := range names {
+		modified[name] = true
 	}
 
-	return reflect.ValueOf(res).Int() == 1, nil
+	var events []Event
+	for _, c := range claims {
+		if modified[c.Event.Name] {
+			events = append(events, c.Event)
+		}
+	}
+	return events, nil
 }
 
-func (r redisTimeline) Find(name string) (Event, error) {
-	cmd := r.cli.ZScore(context.Background(), r.key, name)
-	if cmd.Err() == redis.Nil {
+func (r *redisTimeline) Find(ctx context.Context, name string) (Event, error) {
+	ser, err := r.cli.HGet(ctx, r.stateKey, name).Result()
+	if err == redis.Nil {
 		return Event{}, nil
 	}
-	if cmd.Err() != nil {
-		return Event{}, cmd.Err()
+	if err != nil {
+		return Event{}, err
 	}
 
-	event := Event{Name: name}
-	event.Time, event.Displayed = r.ts2time(int64(cmd.Val()))
-
-	return event, nil
+	return r.decode(name, ser)
 }
 
-func (r redisTimeline) FindEarliest() (Event, error) {
-	res, err := r.cli.ZRangeByScoreWithScores(context.Background(),
-		r.key,
-		&redis.ZRangeBy{
-			Min:   "0",
-			Max:   "inf",
-			Count: 1,
-		}).Result()
+func (r *redisTimeline) FindEarliest(ctx context.Context) (Event, error) {
+	res, err := r.cli.ZRangeWithScores(ctx, r.key, 0, 0).Result()
 	if err != nil {
 		return Event{}, err
 	}
@@ -165,70 +315,136 @@ func (r redisTimeline) FindEarliest() (Event, error) {
 		return Event{}, nil
 	}
 
-	ts := int64(res[0].Score)
-	name := res[0].Member.(string)
-
-	// limited for displayed events
-	return Event{Name: name, Time: time.Unix(ts, 0), Displayed: true}, nil
+	// the schedule only contains displayed events
+	return Event{
+		Name:      res[0].Member.(string),
+		Time:      time.Unix(int64(res[0].Score), 0),
+		Displayed: true,
+	}, nil
 }
 
-func (r redisTimeline) FetchHistory(t time.Time) ([]Event, error) {
-	res, err := r.cli.ZRangeByScoreWithScores(context.Background(), r.key,
-		&redis.ZRangeBy{
-			Min: "0",
-			Max: strconv.Itoa(int(t.Unix())),
-		}).Result()
+// Input:
+// KEYS[1] -> key
+// --
+// ARGV[1] -> cursor.Time (min score)
+// ARGV[2] -> cursor.Name
+// ARGV[3] -> t (max score)
+// ARGV[4] -> limit
+//
+// Output:
+// Returns at most limit members with scores, after the cursor
+var fetchCmd = redis.NewScript(`
+local res = {}
+local min = tonumber(ARGV[1])
+local limit = tonumber(ARGV[4])
+local offset = 0
+while true do
+	local batch = redis.call("ZRANGEBYSCORE", KEYS[1], ARGV[1], ARGV[3], "WITHSCORES", "LIMIT", offset, limit)
+	if #batch == 0 then
+		return res
+	end
+	for i = 1, #batch, 2 do
+		if tonumber(batch[i+1]) > min or batch[i] > ARGV[2] then
+			res[#res+1] = batch[i]
+			res[#res+1] = batch[i+1]
+			if #res == 2 * limit then
+				return res
+			end
+		end
+	end
+	offset = offset + #batch / 2
+end
+`)
+
+func (r *redisTimeline) FetchHistory(ctx context.Context, t time.Time, after Event, limit int64) ([]Event, error) {
+	var (
+		res []redis.Z
+		err error
+	)
+
+	if limit > 0 {
+		keys := []string{
+			r.key,
+		}
+		argv := []interface{}{
+			after.Time.Unix(),
+			after.Name,
+			t.Unix(),
+			limit,
+		}
+		var vals []string
+		vals, err = fetchCmd.Run(ctx, r.cli, keys, argv...).StringSlice()
+		for i := 0; i+1 < len(vals); i += 2 {
+			score, _ := strconv.ParseFloat(vals[i+1], 64)
+			res = append(res, redis.Z{Member: vals[i], Score: score})
+		}
+	} else {
+		res, err = r.cli.ZRangeByScoreWithScores(ctx, r.key,
+			&redis.ZRangeBy{
+				Min: strconv.FormatInt(after.Time.Unix(), 10),
+				Max: strconv.FormatInt(t.Unix(), 10),
+			}).Result()
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	var events = make([]Event, len(res))
+	var events = make([]Event, 0, len(res))
 
-	for i, z := range res {
-		events[i] = Event{
+	for _, z := range res {
+		event := Event{
 			Name:      z.Member.(string),
 			Time:      time.Unix(int64(z.Score), 0),
 			Displayed: true,
 		}
+		if !eventAfter(event, after) {
+			continue
+		}
+		events = append(events, event)
 	}
 
-	// limited for displayed events
+	// the schedule only contains displayed events
 	return events, nil
 }
 
-func (r redisTimeline) Events() ([]Event, error) {
-	res, err := r.cli.ZRangeByScoreWithScores(context.Background(), r.key,
-		&redis.ZRangeBy{
-			Min: "-inf",
-			Max: "inf",
-		}).Result()
+func (r *redisTimeline) Events(ctx context.Context) ([]Event, error) {
+	res, err := r.cli.HGetAll(ctx, r.stateKey).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	var events = make([]Event, len(res))
+	var events = make([]Event, 0, len(res))
 
-	for i, z := range res {
-		event := Event{Name: z.Member.(string)}
-		event.Time, event.Displayed = r.ts2time(int64(z.Score))
-		events[i] = event
+	for name, ser := range res {
+		event, err := r.decode(name, ser)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
 	}
 
+	sortEvents(events)
 	return events, nil
 }
 
-func (r redisTimeline) Close() { r.cli.Close() }
+func (r *redisTimeline) Close() {}
 
-func (r redisTimeline) time2ts(t time.Time, displayed bool) int64 {
-	if !displayed {
-		return -t.Unix()
+func (r *redisTimeline) decode(name, ser string) (Event, error) {
+	var state eventState
+	if err := json.Unmarshal([]byte(ser), &state); err != nil {
+		return Event{}, err
 	}
-	return t.Unix()
+
+	return Event{
+		Name:      name,
+		Time:      time.Unix(state.Time, 0),
+		Displayed: state.Displayed,
+	}, nil
 }
 
-func (r redisTimeline) ts2time(ts int64) (time.Time, bool) {
-	if ts > 0 {
-		return time.Unix(ts, 0), true
+func boolArg(b bool) string {
+	if b {
+		return "1"
 	}
-	return time.Unix(-ts, 0), false
+	return "0"
 }
@@ -0,0 +1,128 @@
+package cron_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yinyajun/cron"
+	"github.com/yinyajun/cron/timelinetest"
+)
+
+func TestRedisTimeline(t *testing.T) {
+	timelinetest.Run(t, func(t *testing.T) cron.Timeline {
+		s := miniredis.RunT(t)
+		cli := redis.NewClient(&redis.Options{Addr: s.Addr()})
+		t.Cleanup(func() { cli.Close() })
+		return cron.NewRedisTimeline(cli, "_timeline")
+	})
+}
+
+func TestMemoryTimeline(t *testing.T) {
+	timelinetest.Run(t, func(t *testing.T) cron.Timeline {
+		return cron.NewMemoryTimeline()
+	})
+}
+
+func TestBoltTimeline(t *testing.T) {
+	timelinetest.Run(t, func(t *testing.T) cron.Timeline {
+		return cron.NewBoltTimeline(openBolt(t), "_timeline")
+	})
+}
+
+func TestSQLTimeline(t *testing.T) {
+	timelinetest.Run(t, func(t *testing.T) cron.Timeline {
+		return cron.NewSQLTimeline(openSQLite(t, "file:"+t.Name()+"?mode=memory&cache=shared"), "_timeline")
+	})
+}
+
+// openSQLite opens a sqlite database closed with the test, connections are
+// serialized as sqlite has a single writer.
+func openSQLite(t *testing.T, dsn string) *sql.DB {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRedisTimelineMigrate(t *testing.T) {
+	var (
+		ctx = context.Background()
+		s   = miniredis.RunT(t)
+		cli = redis.NewClient(&redis.Options{Addr: s.Addr()})
+	)
+	t.Cleanup(func() { cli.Close() })
+
+	// the old encoding: the score is the time of displayed events, the
+	// negated time of hidden ones. Events before the epoch can not be told
+	// from hidden ones after it, they are migrated as the old timeline read
+	// them.
+	scores := map[string]float64{
+		"displayed":           1700000000,
+		"hidden":              -1700000000,
+		"far":                 253402300799,
+		"hidden-epoch":        0,
+		"before-epoch":        -86400,
+		"hidden-before-epoch": 86400,
+	}
+	want := map[string]cron.Event{
+		"displayed":           {Name: "displayed", Time: time.Unix(1700000000, 0), Displayed: true},
+		"hidden":              {Name: "hidden", Time: time.Unix(1700000000, 0)},
+		"far":                 {Name: "far", Time: time.Unix(253402300799, 0), Displayed: true},
+		"hidden-epoch":        {Name: "hidden-epoch", Time: time.Unix(0, 0)},
+		"before-epoch":        {Name: "before-epoch", Time: time.Unix(86400, 0)},
+		"hidden-before-epoch": {Name: "hidden-before-epoch", Time: time.Unix(86400, 0), Displayed: true},
+	}
+	for name, score := range scores {
+		if err := cli.ZAdd(ctx, "_timeline", &redis.Z{Score: score, Member: name}).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func() {
+		t.Helper()
+		tl := cron.NewRedisTimeline(cli, "_timeline")
+
+		events, err := tl.Events(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(want) {
+			t.Fatalf("%d events, want %d", len(events), len(want))
+		}
+		for _, e := range events {
+			w := want[e.Name]
+			if !e.Time.Equal(w.Time) || e.Displayed != w.Displayed {
+				t.Errorf("%s: time %d displayed %v, want time %d displayed %v",
+					e.Name, e.Time.Unix(), e.Displayed, w.Time.Unix(), w.Displayed)
+			}
+		}
+
+		// only the displayed events are scheduled
+		earliest, err := tl.FindEarliest(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if earliest.Name != "hidden-before-epoch" {
+			t.Errorf("earliest %s, want hidden-before-epoch", earliest.Name)
+		}
+	}
+
+	check()
+
+	// a change after the migration is kept by a second one
+	tl := cron.NewRedisTimeline(cli, "_timeline")
+	if err := tl.Display(ctx, "hidden"); err != nil {
+		t.Fatal(err)
+	}
+	want["hidden"] = cron.Event{Name: "hidden", Time: time.Unix(1700000000, 0), Displayed: true}
+	check()
+}
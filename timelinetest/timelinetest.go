@@ -0,0 +1,443 @@
+// Package timelinetest provides a conformance suite for cron.Timeline
+// implementations.
+//
+// A backend is checked by calling Run from its own test:
+//
+//	func TestMyTimeline(t *testing.T) {
+//		timelinetest.Run(t, func(t *testing.T) cron.Timeline {
+//			return NewMyTimeline(...)
+//		})
+//	}
+package timelinetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yinyajun/cron"
+)
+
+// Factory returns an empty Timeline. It is called once per sub test, the
+// returned timeline is closed when the sub test finishes.
+type Factory func(t *testing.T) cron.Timeline
+
+// Run runs the whole conformance suite against the timelines built by f.
+func Run(t *testing.T, f Factory) {
+	cases := []struct {
+		name string
+		fn   func(*testing.T, cron.Timeline)
+	}{
+		{"AddFind", testAddFind},
+		{"Remove", testRemove},
+		{"HideDisplay", testHideDisplay},
+		{"TryModify", testTryModify},
+		{"TryModifyRace", testTryModifyRace},
+		{"TryModifyBatch", testTryModifyBatch},
+		{"TryModifyBatchRace", testTryModifyBatchRace},
+		{"FindEarliest", testFindEarliest},
+		{"FetchHistory", testFetchHistory},
+		{"FetchHistoryPages", testFetchHistoryPages},
+		{"Events", testEvents},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			tl := f(t)
+			defer tl.Close()
+			c.fn(t, tl)
+		})
+	}
+}
+
+var ctx = context.Background()
+
+// base is a second-aligned time in the future, timelines only keep second
+// precision.
+func base() time.Time {
+	return time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+}
+
+func mustAdd(t *testing.T, tl cron.Timeline, events ...cron.Event) {
+	t.Helper()
+	for _, e := range events {
+		if err := tl.Add(ctx, e); err != nil {
+			t.Fatalf("Add(%s): %v", e.Name, err)
+		}
+	}
+}
+
+func mustFind(t *testing.T, tl cron.Timeline, name string) cron.Event {
+	t.Helper()
+	e, err := tl.Find(ctx, name)
+	if err != nil {
+		t.Fatalf("Find(%s): %v", name, err)
+	}
+	return e
+}
+
+func assertEvent(t *testing.T, got, want cron.Event) {
+	t.Helper()
+	if got.Name != want.Name || got.Time.Unix() != want.Time.Unix() || got.Displayed != want.Displayed {
+		t.Fatalf("event = {%s %d %v}, want {%s %d %v}",
+			got.Name, got.Time.Unix(), got.Displayed,
+			want.Name, want.Time.Unix(), want.Displayed)
+	}
+}
+
+func assertNames(t *testing.T, events []cron.Event, want ...string) {
+	t.Helper()
+	got := make([]string, len(events))
+	for i, e := range events {
+		got[i] = e.Name
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func testAddFind(t *testing.T, tl cron.Timeline) {
+	now := base()
+	shown := cron.Event{Name: "shown", Time: now, Displayed: true}
+	hidden := cron.Event{Name: "hidden", Time: now, Displayed: false}
+	mustAdd(t, tl, shown, hidden)
+
+	assertEvent(t, mustFind(t, tl, "shown"), shown)
+	assertEvent(t, mustFind(t, tl, "hidden"), hidden)
+
+	if e := mustFind(t, tl, "missing"); !e.IsEmpty() {
+		t.Fatalf("Find(missing) = %v, want empty event", e)
+	}
+
+	// add again overwrites the event
+	shown.Time = now.Add(time.Minute)
+	mustAdd(t, tl, shown)
+	assertEvent(t, mustFind(t, tl, "shown"), shown)
+}
+
+func testRemove(t *testing.T, tl cron.Timeline) {
+	mustAdd(t, tl, cron.Event{Name: "a", Time: base(), Displayed: true})
+
+	if err := tl.Remove(ctx, "a"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if e := mustFind(t, tl, "a"); !e.IsEmpty() {
+		t.Fatalf("Find after Remove = %v, want empty event", e)
+	}
+	if err := tl.Remove(ctx, "a"); err != nil {
+		t.Fatalf("Remove twice: %v", err)
+	}
+}
+
+func testHideDisplay(t *testing.T, tl cron.Timeline) {
+	now := base()
+	mustAdd(t, tl, cron.Event{Name: "a", Time: now, Displayed: true})
+
+	for i := 0; i < 2; i++ {
+		if err := tl.Hide(ctx, "a"); err != nil {
+			t.Fatalf("Hide: %v", err)
+		}
+		assertEvent(t, mustFind(t, tl, "a"), cron.Event{Name: "a", Time: now, Displayed: false})
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := tl.Display(ctx, "a"); err != nil {
+			t.Fatalf("Display: %v", err)
+		}
+		assertEvent(t, mustFind(t, tl, "a"), cron.Event{Name: "a", Time: now, Displayed: true})
+	}
+
+	if err := tl.Hide(ctx, "missing"); err == nil {
+		t.Fatal("Hide(missing) succeeded, want error")
+	}
+	if err := tl.Display(ctx, "missing"); err == nil {
+		t.Fatal("Display(missing) succeeded, want error")
+	}
+}
+
+func testTryModify(t *testing.T, tl cron.Timeline) {
+	now := base()
+	event := cron.Event{Name: "a", Time: now, Displayed: true}
+	mustAdd(t, tl, event)
+
+	next := now.Add(time.Minute)
+	ok, err := tl.TryModify(ctx, event, next)
+	if err != nil || !ok {
+		t.Fatalf("TryModify = %v, %v; want true, nil", ok, err)
+	}
+	assertEvent(t, mustFind(t, tl, "a"), cron.Event{Name: "a", Time: next, Displayed: true})
+
+	// stale event
+	ok, err = tl.TryModify(ctx, event, next.Add(time.Minute))
+	if err != nil || ok {
+		t.Fatalf("TryModify(stale) = %v, %v; want false, nil", ok, err)
+	}
+
+	// displayed state is part of the comparison
+	if err := tl.Hide(ctx, "a"); err != nil {
+		t.Fatalf("Hide: %v", err)
+	}
+	ok, err = tl.TryModify(ctx, cron.Event{Name: "a", Time: next, Displayed: true}, next.Add(time.Minute))
+	if err != nil || ok {
+		t.Fatalf("TryModify(hidden) = %v, %v; want false, nil", ok, err)
+	}
+
+	// missing event
+	ok, err = tl.TryModify(ctx, cron.Event{Name: "missing", Time: now, Displayed: true}, next)
+	if err != nil || ok {
+		t.Fatalf("TryModify(missing) = %v, %v; want false, nil", ok, err)
+	}
+	if e := mustFind(t, tl, "missing"); !e.IsEmpty() {
+		t.Fatalf("TryModify created event %v", e)
+	}
+}
+
+func testTryModifyRace(t *testing.T, tl cron.Timeline) {
+	const workers = 16
+
+	now := base()
+	event := cron.Event{Name: "a", Time: now, Displayed: true}
+	mustAdd(t, tl, event)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		wins int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := tl.TryModify(ctx, event, now.Add(time.Duration(i+1)*time.Second))
+			if err != nil {
+				t.Errorf("TryModify: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if wins != 1 {
+		t.Fatalf("%d concurrent TryModify succeeded, want 1", wins)
+	}
+}
+
+func testTryModifyBatch(t *testing.T, tl cron.Timeline) {
+	events, err := tl.TryModifyBatch(ctx, nil)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("TryModifyBatch(nil) = %v, %v; want none", events, err)
+	}
+
+	now := base()
+	a := cron.Event{Name: "a", Time: now, Displayed: true}
+	b := cron.Event{Name: "b", Time: now, Displayed: true}
+	c := cron.Event{Name: "c", Time: now, Displayed: true}
+	mustAdd(t, tl, a, b, c)
+
+	next := now.Add(time.Minute)
+	events, err = tl.TryModifyBatch(ctx, []cron.Claim{
+		{Event: c, Next: next},
+		{Event: cron.Event{Name: "b", Time: now.Add(-time.Second), Displayed: true}, Next: next},
+		{Event: cron.Event{Name: "missing", Time: now, Displayed: true}, Next: next},
+		{Event: a, Next: next},
+	})
+	if err != nil {
+		t.Fatalf("TryModifyBatch: %v", err)
+	}
+	assertNames(t, events, "c", "a")
+
+	assertEvent(t, mustFind(t, tl, "a"), cron.Event{Name: "a", Time: next, Displayed: true})
+	assertEvent(t, mustFind(t, tl, "b"), b)
+	assertEvent(t, mustFind(t, tl, "c"), cron.Event{Name: "c", Time: next, Displayed: true})
+
+	// already modified
+	events, err = tl.TryModifyBatch(ctx, []cron.Claim{{Event: a, Next: next.Add(time.Minute)}})
+	if err != nil || len(events) != 0 {
+		t.Fatalf("TryModifyBatch(stale) = %v, %v; want none", events, err)
+	}
+}
+
+func testTryModifyBatchRace(t *testing.T, tl cron.Timeline) {
+	const (
+		workers = 8
+		size    = 32
+	)
+
+	now := base()
+	claims := make([]cron.Claim, size)
+	for i := range claims {
+		e := cron.Event{Name: fmt.Sprintf("e%02d", i), Time: now, Displayed: true}
+		mustAdd(t, tl, e)
+		claims[i] = cron.Claim{Event: e, Next: now.Add(time.Minute)}
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		wins = make(map[string]int)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, err := tl.TryModifyBatch(ctx, claims)
+			if err != nil {
+				t.Errorf("TryModifyBatch: %v", err)
+				return
+			}
+			mu.Lock()
+			for _, e := range events {
+				wins[e.Name]++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range claims {
+		if n := wins[c.Event.Name]; n != 1 {
+			t.Fatalf("event %s claimed %d times, want 1", c.Event.Name, n)
+		}
+	}
+}
+
+func testFindEarliest(t *testing.T, tl cron.Timeline) {
+	e, err := tl.FindEarliest(ctx)
+	if err != nil || !e.IsEmpty() {
+		t.Fatalf("FindEarliest(empty) = %v, %v; want empty event", e, err)
+	}
+
+	now := base()
+	mustAdd(t, tl,
+		cron.Event{Name: "hidden", Time: now, Displayed: false},
+		cron.Event{Name: "late", Time: now.Add(2 * time.Minute), Displayed: true},
+		cron.Event{Name: "early", Time: now.Add(time.Minute), Displayed: true},
+	)
+
+	e, err = tl.FindEarliest(ctx)
+	if err != nil {
+		t.Fatalf("FindEarliest: %v", err)
+	}
+	assertEvent(t, e, cron.Event{Name: "early", Time: now.Add(time.Minute), Displayed: true})
+
+	if err := tl.Hide(ctx, "early"); err != nil {
+		t.Fatalf("Hide: %v", err)
+	}
+	e, err = tl.FindEarliest(ctx)
+	if err != nil {
+		t.Fatalf("FindEarliest: %v", err)
+	}
+	assertEvent(t, e, cron.Event{Name: "late", Time: now.Add(2 * time.Minute), Displayed: true})
+}
+
+func testFetchHistory(t *testing.T, tl cron.Timeline) {
+	now := base()
+	mustAdd(t, tl,
+		cron.Event{Name: "before", Time: now.Add(-time.Second), Displayed: true},
+		cron.Event{Name: "at", Time: now, Displayed: true},
+		cron.Event{Name: "after", Time: now.Add(time.Second), Displayed: true},
+		cron.Event{Name: "hidden", Time: now.Add(-time.Minute), Displayed: false},
+	)
+
+	events, err := tl.FetchHistory(ctx, now.Add(-time.Minute), cron.Event{}, 0)
+	if err != nil {
+		t.Fatalf("FetchHistory: %v", err)
+	}
+	assertNames(t, events)
+
+	events, err = tl.FetchHistory(ctx, now, cron.Event{}, 0)
+	if err != nil {
+		t.Fatalf("FetchHistory: %v", err)
+	}
+	assertNames(t, events, "before", "at")
+	for _, e := range events {
+		if !e.Displayed {
+			t.Fatalf("FetchHistory returned hidden event %s", e.Name)
+		}
+	}
+	assertEvent(t, events[1], cron.Event{Name: "at", Time: now, Displayed: true})
+}
+
+func testFetchHistoryPages(t *testing.T, tl cron.Timeline) {
+	now := base()
+	mustAdd(t, tl,
+		cron.Event{Name: "d", Time: now.Add(-time.Minute), Displayed: true},
+		cron.Event{Name: "c", Time: now, Displayed: true},
+		cron.Event{Name: "b", Time: now, Displayed: true},
+		cron.Event{Name: "a", Time: now, Displayed: true},
+		cron.Event{Name: "e", Time: now, Displayed: false},
+		cron.Event{Name: "f", Time: now.Add(time.Second), Displayed: true},
+	)
+
+	var (
+		cursor cron.Event
+		pages  [][]string
+	)
+	for i := 0; i < 10; i++ {
+		events, err := tl.FetchHistory(ctx, now, cursor, 2)
+		if err != nil {
+			t.Fatalf("FetchHistory: %v", err)
+		}
+		if len(events) == 0 {
+			break
+		}
+		if len(events) > 2 {
+			t.Fatalf("FetchHistory returned %d events, limit 2", len(events))
+		}
+
+		var names []string
+		for _, e := range events {
+			names = append(names, e.Name)
+		}
+		pages = append(pages, names)
+		cursor = events[len(events)-1]
+	}
+
+	if got, want := fmt.Sprint(pages), "[[d a] [b c]]"; got != want {
+		t.Fatalf("pages = %s, want %s", got, want)
+	}
+
+	// the cursor does not need to exist any more
+	if _, err := tl.TryModify(ctx, cron.Event{Name: "a", Time: now, Displayed: true}, now.Add(time.Hour)); err != nil {
+		t.Fatalf("TryModify: %v", err)
+	}
+	events, err := tl.FetchHistory(ctx, now, cron.Event{Name: "a", Time: now}, 10)
+	if err != nil {
+		t.Fatalf("FetchHistory: %v", err)
+	}
+	assertNames(t, events, "b", "c")
+}
+
+func testEvents(t *testing.T, tl cron.Timeline) {
+	events, err := tl.Events(ctx)
+	if err != nil || len(events) != 0 {
+		t.Fatalf("Events(empty) = %v, %v; want none", events, err)
+	}
+
+	now := base()
+	mustAdd(t, tl,
+		cron.Event{Name: "c", Time: now.Add(time.Minute), Displayed: true},
+		cron.Event{Name: "b", Time: now, Displayed: true},
+		cron.Event{Name: "a", Time: now, Displayed: true},
+		cron.Event{Name: "hidden", Time: now, Displayed: false},
+	)
+
+	events, err = tl.Events(ctx)
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+
+	// ordered by time and then by name, hidden events included
+	assertNames(t, events, "a", "b", "hidden", "c")
+	if events[2].Displayed {
+		t.Fatal("Events lost hidden state")
+	}
+}
@@ -1,6 +1,7 @@
 package cron
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -26,7 +27,27 @@ func init() {
 type Conf struct {
 	Base struct {
 		HttpAddr     string        `json:"http_addr"`
+		Storage      string        `json:"storage"`
+		EntryStore   string        `json:"entry_store"`
+		Standalone   bool          `json:"standalone"` // single node, entries are not shared
 		RedisOptions redis.Options `json:"redis"`
+		// RedisSentinel enables sentinel failover when MasterName is set
+		RedisSentinel struct {
+			MasterName string   `json:"master_name"`
+			Addrs      []string `json:"addrs"`
+			Password   string   `json:"password"`
+		} `json:"redis_sentinel"`
+		// RedisCluster enables redis cluster when Addrs is set
+		RedisCluster struct {
+			Addrs []string `json:"addrs"`
+		} `json:"redis_cluster"`
+		SQL struct {
+			Driver string `json:"driver"`
+			DSN    string `json:"dsn"`
+		} `json:"sql"`
+		Bolt struct {
+			Path string `json:"path"`
+		} `json:"bolt"`
 	} `json:"base"`
 
 	Gossip struct {
@@ -34,13 +55,42 @@ type Conf struct {
 		NodeName string `json:"node_name"`
 		BindAddr string `json:"bind_addr"`
 		BindPort int    `json:"bind_port"`
+		// Roles are advertised to the other nodes in node meta
+		Roles []string `json:"roles"`
+		// Labels are matched by the placement of entries
+		Labels map[string]string `json:"labels"`
+		// AdvertiseAddr and AdvertisePort are advertised to the other nodes
+		// instead of the bind address, e.g. behind a NAT
+		AdvertiseAddr string `json:"advertise_addr"`
+		AdvertisePort int    `json:"advertise_port"`
+		// SecretKey encrypts gossip, base64 of 16, 24 or 32 bytes
+		SecretKey string `json:"secret_key"`
+		// SecondaryKeys also decrypt gossip, while keys are rotated
+		SecondaryKeys []string `json:"secondary_keys"`
+		// AllowPlaintext accepts and sends unencrypted gossip, while
+		// encryption is enabled on a running cluster
+		AllowPlaintext bool `json:"allow_plaintext"`
+		// KeyRotation enables the rotation of keys by POST /api/v1/keys,
+		// the http api is not authenticated
+		KeyRotation bool `json:"key_rotation"`
+		// Label is prepended to gossip, nodes of another label are rejected
+		Label string `json:"label"`
 	} `json:"gossip"`
 
 	Custom struct {
 		KeyTimeline   string `json:"key_timeline"`
 		KeyEntry      string `json:"key_entry"`
 		KeyExecutor   string `json:"key_executor"`
+		KeyStream     string `json:"key_stream"`
+		StreamMaxLen  int64  `json:"stream_max_len"` // 0 disables the change stream
 		MaxHistoryNum int64  `json:"max_history_num"`
+		FetchPageSize int64  `json:"fetch_page_size"`
+		StoreTimeout  int64  `json:"store_timeout"` // milliseconds
+		TombstoneTTL  int64  `json:"tombstone_ttl"` // seconds, negative keeps tombstones forever
+		// ReconcileInterval is in seconds, negative disables the reconciler
+		ReconcileInterval int64 `json:"reconcile_interval"`
+		// StrictRestore refuses to start if some entries are not restored
+		StrictRestore bool `json:"strict_restore"`
 	} `json:"custom"`
 }
 
@@ -62,6 +112,15 @@ func (c *Conf) WithDefault() {
 	if c.Base.HttpAddr == "" {
 		c.Base.HttpAddr = ":8080"
 	}
+	if c.Base.Storage == "" {
+		c.Base.Storage = "redis"
+	}
+	if c.Base.EntryStore == "" {
+		c.Base.EntryStore = "gossip"
+	}
+	if c.Base.Bolt.Path == "" {
+		c.Base.Bolt.Path = "./cron.db"
+	}
 
 	// gossip
 	if c.Gossip.Network == "" {
@@ -73,6 +132,9 @@ func (c *Conf) WithDefault() {
 	if c.Gossip.BindPort == 0 {
 		c.Gossip.BindPort = 7946
 	}
+	if c.Gossip.AdvertisePort == 0 {
+		c.Gossip.AdvertisePort = c.Gossip.BindPort
+	}
 	if c.Gossip.NodeName == "" {
 		hostname, _ := os.Hostname()
 		c.Gossip.NodeName = hostname
@@ -88,7 +150,31 @@ func (c *Conf) WithDefault() {
 	if c.Custom.KeyExecutor == "" {
 		c.Custom.KeyExecutor = "_exe"
 	}
-	if c.Custom.MaxHistoryNum == 0 {
+	if c.Custom.KeyStream == "" {
+		c.Custom.KeyStream = "_stream"
+	}
+	if c.Custom.MaxHistoryNum <= 0 {
 		c.Custom.MaxHistoryNum = 5
 	}
+	if c.Custom.FetchPageSize == 0 {
+		c.Custom.FetchPageSize = 500
+	}
+	if c.Custom.StoreTimeout == 0 {
+		c.Custom.StoreTimeout = 3000
+	}
+	if c.Custom.TombstoneTTL == 0 {
+		c.Custom.TombstoneTTL = 86400
+	}
+	if c.Custom.ReconcileInterval == 0 {
+		c.Custom.ReconcileInterval = 300
+	}
+}
+
+// withTimeout derives the context of one storage call, d <= 0 means no
+// timeout.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
 }
@@ -0,0 +1,61 @@
+package cron
+
+import (
+	"sync"
+	"time"
+)
+
+// Version orders the changes of an entry made on different nodes. It is a
+// hybrid logical clock stamped by the node making the change, the node name
+// breaks the ties, so all the nodes agree on the newest change.
+type Version struct {
+	Wall    int64  `json:"wall"` // unix milliseconds
+	Logical int64  `json:"logical"`
+	Node    string `json:"node"`
+}
+
+func (v Version) IsZero() bool { return v == Version{} }
+
+// Less reports whether v is older than o
+func (v Version) Less(o Version) bool {
+	if v.Wall != o.Wall {
+		return v.Wall < o.Wall
+	}
+	if v.Logical != o.Logical {
+		return v.Logical < o.Logical
+	}
+	return v.Node < o.Node
+}
+
+// hlc is a hybrid logical clock, versions it stamps are newer than all the
+// versions it has stamped or observed.
+type hlc struct {
+	mu   sync.Mutex
+	node string
+	last Version
+}
+
+func (c *hlc) Now() Version {
+	wall := time.Now().UnixNano() / int64(time.Millisecond)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if wall > c.last.Wall {
+		c.last = Version{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	c.last.Node = c.node
+	return c.last
+}
+
+// Observe advances the clock to a version stamped by another node
+func (c *hlc) Observe(v Version) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last.Wall < v.Wall || (c.last.Wall == v.Wall && c.last.Logical < v.Logical) {
+		c.last.Wall, c.last.Logical = v.Wall, v.Logical
+	}
+}